@@ -2,14 +2,14 @@ package credentials
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
-	"regexp"
 	"strings"
+	"time"
 )
 
 type ProverCredential struct {
@@ -19,11 +19,18 @@ type ProverCredential struct {
 	ClientSecret    string
 	AccessToken     string
 	UrlPrivateParts string
+	// refresh token and expiry of the access token, zero if it does not expire
+	RefreshToken string
+	Expiry       time.Time
 }
 
 type CredsClient struct {
 	Cred     ProverCredential
 	CredName string
+	// credential file updated with new tokens
+	Path string
+	// client of token requests, a client with timeout if nil
+	HTTPClient *http.Client
 }
 
 func NewCredsClient(credName string) (*CredsClient, error) {
@@ -31,6 +38,7 @@ func NewCredsClient(credName string) (*CredsClient, error) {
 	// init cc
 	cc := new(CredsClient)
 	cc.CredName = credName
+	cc.Path = "prover/credentials/" + credName + ".json"
 
 	// parse json file
 	jsonFile, err := os.Open("prover/credentials/" + credName + ".json")
@@ -47,71 +55,20 @@ func NewCredsClient(credName string) (*CredsClient, error) {
 	return cc, nil
 }
 
-func (cc *CredsClient) RequestToken() error {
-
-	// Generated by curl-to-Go: https://mholt.github.io/curl-to-go
-
-	// curl -v POST https://api.sandbox.paypal.com/v1/oauth2/token \                                                                          ~/Documents/coding/play/curlGo
-	//   -H "Accept: application/json" \
-	//   -H "Accept-Language: en_US" \
-	//   -u "AUv8rrc_P-EbP2E0mpb49BV7rFt3Usr-vdUZO8VGOnjRehGHBXkSzchr37SYF2GNdQFYSp72jh5QUhzG:EMnAWe06ioGtouJs7gLYT9chK9-2jJ--7MKRXpI8FesmY_2Kp-d_7aCqff7M9moEJBvuXoBO4clKtY0v" \
-	//   -d "grant_type=client_credentials"
-
-	params := url.Values{}
-	params.Add("grant_type", `client_credentials`)
-	body := strings.NewReader(params.Encode())
-
-	req, err := http.NewRequest("POST", cc.Cred.TokenURL, body)
+// LoadCredential reads the prover credential file at path
+func LoadCredential(path string) (ProverCredential, error) {
+	var cred ProverCredential
+	byteValue, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Println("http.NewRequest error:", err)
-		return err
+		log.Println("ioutil.ReadFile() error", err)
+		return cred, err
 	}
-	req.SetBasicAuth(cc.Cred.ClientID, cc.Cred.ClientSecret)
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Accept-Language", "en_US")
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := http.DefaultClient.Do(req)
+	err = json.Unmarshal(byteValue, &cred)
 	if err != nil {
-		log.Println("http.DefaultClient error:", err)
-		return err
+		log.Println("json.Unmarshal() error", err)
+		return cred, err
 	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	// fmt.Println(string(respBody))
-
-	// extract access_token
-	re := regexp.MustCompile(`"access_token":"(.*)"`)
-	match := re.FindStringSubmatch(string(respBody))
-
-	// fmt.Println(strings.Split(match[0], "\":\"")[1])
-	access_token := strings.Split(strings.Split(match[0], "\":\"")[1], "\"")[0]
-	// fmt.Println(access_token)
-
-	// overwrite old value
-	cc.Cred.AccessToken = access_token
-
-	// lower part commented out as same functionality implemented in SetOrder function which is called right afterwards in commands/cmd_prover.go
-
-	// write access token to credentials file
-	// s, err := json.MarshalIndent(cc.Cred, "", "\t");
-	// if err != nil {
-	// log.Println("json.MashalIndent() error:", err)
-	// return err
-	// }
-
-	// err = ioutil.WriteFile("prover/credentials/"+cc.CredName+".json", s, 0644)
-	// if err != nil {
-	// log.Println("ioutil.WriteFile error:", err)
-	// return err
-	// }
-
-	return nil
+	return cred, nil
 }
 
 func (cc *CredsClient) SetOrder() error {
@@ -180,26 +137,32 @@ func (cc *CredsClient) SetOrder() error {
 	}
 	// fmt.Println(string(body))
 
-	// regex extract to get order identifier
-	re := regexp.MustCompile(`"id":"(.*)"`)
-	match := re.FindStringSubmatch(string(respBody))
-
-	order_id := strings.Split(strings.Split(match[0], "\":\"")[1], "\"")[0]
-
-	cc.Cred.UrlPrivateParts = order_id
-
-	// write URL private parts to credentials file
-	s, err := json.MarshalIndent(cc.Cred, "", "\t")
+	// order identifier which can later be queried
+	orderID, err := parseOrderID(res.StatusCode, respBody)
 	if err != nil {
-		log.Println("json.MashalIndent() error:", err)
+		log.Println("parseOrderID() error:", err)
 		return err
 	}
+	cc.Cred.UrlPrivateParts = orderID
 
-	err = ioutil.WriteFile("prover/credentials/"+cc.CredName+".json", s, 0644)
+	// write URL private parts to credentials file
+	return cc.store()
+}
+
+// parseOrderID returns the id of the order created with status and body
+func parseOrderID(status int, body []byte) (string, error) {
+	if status != http.StatusOK && status != http.StatusCreated {
+		return "", fmt.Errorf("order request: status %d", status)
+	}
+	var order struct {
+		ID string `json:"id"`
+	}
+	err := json.Unmarshal(body, &order)
 	if err != nil {
-		log.Println("ioutil.WriteFile error:", err)
-		return err
+		return "", fmt.Errorf("order response: %w", err)
 	}
-
-	return nil
+	if order.ID == "" {
+		return "", errors.New("order response without id")
+	}
+	return order.ID, nil
 }
@@ -0,0 +1,39 @@
+package credentials
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOrderID(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		id     string
+		err    string
+	}{
+		{"created", 201, `{"id":"5O190127TN364715T","status":"CREATED","links":[{"href":"https://api.example.com","rel":"self"}]}`, "5O190127TN364715T", ""},
+		{"spaced json", 200, "{\n  \"status\": \"CREATED\",\n  \"id\": \"ORDER-1\"\n}", "ORDER-1", ""},
+		{"nested id only", 201, `{"purchase_units":[{"id":"unit"}]}`, "", "without id"},
+		{"no json", 201, `<html></html>`, "", "order response"},
+		{"error status", 422, `{"name":"UNPROCESSABLE_ENTITY"}`, "", "status 422"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := parseOrderID(tt.status, []byte(tt.body))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != tt.id {
+				t.Errorf("id %q, want %q", id, tt.id)
+			}
+		})
+	}
+}
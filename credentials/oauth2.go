@@ -0,0 +1,186 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// tokens are refreshed this long before they expire
+const expiryDelta = 30 * time.Second
+
+// timeout of token requests of the default client
+const tokenTimeout = 30 * time.Second
+
+// tokenResponse is the access token or error response of rfc 6749
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewCredsClientFromFile loads the credential file at path,
+// new tokens are written back to the same file
+func NewCredsClientFromFile(path string) (*CredsClient, error) {
+	cred, err := LoadCredential(path)
+	if err != nil {
+		return nil, err
+	}
+	return &CredsClient{Cred: cred, Path: path}, nil
+}
+
+// Valid reports whether the access token is set and does not expire within expiryDelta
+func (c ProverCredential) Valid(now time.Time) bool {
+	return c.AccessToken != "" && (c.Expiry.IsZero() || now.Add(expiryDelta).Before(c.Expiry))
+}
+
+// Token returns a valid access token, expired tokens are refreshed with the refresh
+// token or requested anew with the client credentials and stored in the credential file
+func (cc *CredsClient) Token() (string, error) {
+	if cc.Cred.Valid(time.Now()) {
+		return cc.Cred.AccessToken, nil
+	}
+	if cc.Cred.TokenURL == "" {
+		return "", errors.New("access token missing or expired and credential has no token url")
+	}
+
+	// refresh tokens may be revoked, fall back to the client credentials
+	var err error
+	if cc.Cred.RefreshToken != "" {
+		err = cc.RefreshToken()
+		if err != nil {
+			log.Println("cc.RefreshToken() error", err)
+		}
+	}
+	if cc.Cred.RefreshToken == "" || err != nil {
+		err = cc.RequestToken()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = cc.store()
+	if err != nil {
+		return "", err
+	}
+	return cc.Cred.AccessToken, nil
+}
+
+// RequestToken requests an access token with the client credentials grant
+func (cc *CredsClient) RequestToken() error {
+	params := url.Values{}
+	params.Add("grant_type", "client_credentials")
+	return cc.requestToken(params)
+}
+
+// RefreshToken requests a new access token with the refresh token grant
+func (cc *CredsClient) RefreshToken() error {
+	if cc.Cred.RefreshToken == "" {
+		return errors.New("no refresh token")
+	}
+	params := url.Values{}
+	params.Add("grant_type", "refresh_token")
+	params.Add("refresh_token", cc.Cred.RefreshToken)
+	return cc.requestToken(params)
+}
+
+// requestToken posts the grant to the token endpoint and stores the issued tokens
+func (cc *CredsClient) requestToken(params url.Values) error {
+
+	if cc.Cred.TokenURL == "" {
+		return errors.New("credential has no token url")
+	}
+	req, err := http.NewRequest(http.MethodPost, cc.Cred.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		log.Println("http.NewRequest error:", err)
+		return err
+	}
+	req.SetBasicAuth(url.QueryEscape(cc.Cred.ClientID), url.QueryEscape(cc.Cred.ClientSecret))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := cc.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: tokenTimeout}
+	}
+	requested := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("client.Do() error:", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("ioutil.ReadAll() error:", err)
+		return err
+	}
+
+	// token or error response
+	var token tokenResponse
+	err = json.Unmarshal(respBody, &token)
+	if err != nil {
+		return fmt.Errorf("token response with status %d is no json: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		if token.Error == "" {
+			return fmt.Errorf("token request failed with status %d", resp.StatusCode)
+		}
+		if token.ErrorDescription != "" {
+			return fmt.Errorf("token request failed with status %d: %s: %s", resp.StatusCode, token.Error, token.ErrorDescription)
+		}
+		return fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, token.Error)
+	}
+	if token.AccessToken == "" {
+		return errors.New("token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+
+	// expiry relative to the request, refresh tokens are kept if not reissued
+	cc.Cred.AccessToken = token.AccessToken
+	cc.Cred.Expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		cc.Cred.Expiry = requested.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if token.RefreshToken != "" {
+		cc.Cred.RefreshToken = token.RefreshToken
+	}
+	return nil
+}
+
+// store writes the credential with its tokens to the credential file,
+// readable by the owner only
+func (cc *CredsClient) store() error {
+	if cc.Path == "" {
+		return nil
+	}
+	s, err := json.MarshalIndent(cc.Cred, "", "\t")
+	if err != nil {
+		log.Println("json.MarshalIndent() error:", err)
+		return err
+	}
+	err = ioutil.WriteFile(cc.Path, s, 0600)
+	if err != nil {
+		log.Println("ioutil.WriteFile error:", err)
+		return err
+	}
+	// files created before keep their mode on write
+	err = os.Chmod(cc.Path, 0600)
+	if err != nil {
+		log.Println("os.Chmod error:", err)
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package credentials
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenReply is the response of the test token endpoint to a grant
+type tokenReply struct {
+	status int
+	body   string
+}
+
+func TestToken(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	valid := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		cred ProverCredential
+		// replies per grant type, the token url is set if not nil
+		replies map[string]tokenReply
+		token   string
+		refresh string
+		// grant types requested in order
+		grants []string
+		err    string
+	}{
+		{"cached token", ProverCredential{AccessToken: "cached", Expiry: valid}, map[string]tokenReply{}, "cached", "", nil, ""},
+		{"token without expiry", ProverCredential{AccessToken: "cached"}, nil, "cached", "", nil, ""},
+		{"refresh", ProverCredential{AccessToken: "old", Expiry: expired, RefreshToken: "r1"},
+			map[string]tokenReply{"refresh_token": {200, `{"access_token":"new","token_type":"Bearer","expires_in":3600,"refresh_token":"r2"}`}},
+			"new", "r2", []string{"refresh_token"}, ""},
+		{"refresh token kept", ProverCredential{AccessToken: "old", Expiry: expired, RefreshToken: "r1"},
+			map[string]tokenReply{"refresh_token": {200, `{"access_token":"new","expires_in":3600}`}},
+			"new", "r1", []string{"refresh_token"}, ""},
+		{"refresh falls back to client credentials", ProverCredential{Expiry: expired, RefreshToken: "revoked"},
+			map[string]tokenReply{
+				"refresh_token":      {400, `{"error":"invalid_grant"}`},
+				"client_credentials": {200, `{"access_token":"new","token_type":"bearer","expires_in":60}`},
+			},
+			"new", "revoked", []string{"refresh_token", "client_credentials"}, ""},
+		{"expiring token", ProverCredential{AccessToken: "old", Expiry: time.Now().Add(expiryDelta / 2)},
+			map[string]tokenReply{"client_credentials": {200, `{"access_token":"new"}`}},
+			"new", "", []string{"client_credentials"}, ""},
+
+		{"no token url", ProverCredential{AccessToken: "old", Expiry: expired}, nil, "", "", nil, "no token url"},
+		{"no token", ProverCredential{}, nil, "", "", nil, "no token url"},
+		{"error json", ProverCredential{},
+			map[string]tokenReply{"client_credentials": {401, `{"error":"invalid_client","error_description":"bad secret"}`}},
+			"", "", []string{"client_credentials"}, "status 401: invalid_client: bad secret"},
+		{"error json with status ok", ProverCredential{},
+			map[string]tokenReply{"client_credentials": {200, `{"error":"unauthorized_client"}`}},
+			"", "", []string{"client_credentials"}, "unauthorized_client"},
+		{"status without error json", ProverCredential{},
+			map[string]tokenReply{"client_credentials": {500, `{}`}},
+			"", "", []string{"client_credentials"}, "failed with status 500"},
+		{"no json", ProverCredential{},
+			map[string]tokenReply{"client_credentials": {502, `<html>bad gateway</html>`}},
+			"", "", []string{"client_credentials"}, "is no json"},
+		{"no access token", ProverCredential{},
+			map[string]tokenReply{"client_credentials": {200, `{"token_type":"bearer"}`}},
+			"", "", []string{"client_credentials"}, "no access_token"},
+		{"mac token", ProverCredential{},
+			map[string]tokenReply{"client_credentials": {200, `{"access_token":"new","token_type":"mac"}`}},
+			"", "", []string{"client_credentials"}, "unsupported token type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var grants []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				id, secret, ok := req.BasicAuth()
+				if !ok || id != "client" || secret != "secret" {
+					t.Errorf("basic auth %q %q", id, secret)
+				}
+				grant := req.PostFormValue("grant_type")
+				grants = append(grants, grant)
+				if grant == "refresh_token" && req.PostFormValue("refresh_token") != tt.cred.RefreshToken {
+					t.Errorf("refresh token %q", req.PostFormValue("refresh_token"))
+				}
+				reply, ok := tt.replies[grant]
+				if !ok {
+					t.Errorf("unexpected grant %q", grant)
+					reply = tokenReply{400, `{"error":"unsupported_grant_type"}`}
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(reply.status)
+				w.Write([]byte(reply.body))
+			}))
+			defer server.Close()
+
+			cred := tt.cred
+			cred.ClientID = "client"
+			cred.ClientSecret = "secret"
+			if tt.replies != nil {
+				cred.TokenURL = server.URL
+			}
+			path := filepath.Join(t.TempDir(), "credential.json")
+			cc := &CredsClient{Cred: cred, Path: path, HTTPClient: server.Client()}
+
+			token, err := cc.Token()
+			if !reflect.DeepEqual(grants, tt.grants) {
+				t.Errorf("grants %v, want %v", grants, tt.grants)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != tt.token {
+				t.Errorf("token %q, want %q", token, tt.token)
+			}
+
+			// issued tokens are persisted, cached tokens leave the file untouched
+			stored, err := LoadCredential(path)
+			if len(tt.grants) == 0 {
+				if err == nil {
+					t.Error("credential file written for a cached token")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadCredential: %v", err)
+			}
+			if stored.AccessToken != tt.token || stored.RefreshToken != tt.refresh {
+				t.Errorf("stored tokens %q and %q, want %q and %q", stored.AccessToken, stored.RefreshToken, tt.token, tt.refresh)
+			}
+			if !stored.Expiry.Equal(cc.Cred.Expiry) {
+				t.Errorf("stored expiry %v, want %v", stored.Expiry, cc.Cred.Expiry)
+			}
+			if stored.ClientSecret != "secret" || stored.TokenURL != server.URL {
+				t.Errorf("stored credential lost client credentials: %+v", stored)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("credential file mode %v, want 0600", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		cred  ProverCredential
+		valid bool
+	}{
+		{"no expiry", ProverCredential{AccessToken: "a"}, true},
+		{"expires later", ProverCredential{AccessToken: "a", Expiry: now.Add(time.Hour)}, true},
+		{"expires within delta", ProverCredential{AccessToken: "a", Expiry: now.Add(expiryDelta - time.Second)}, false},
+		{"expired", ProverCredential{AccessToken: "a", Expiry: now.Add(-time.Second)}, false},
+		{"no token", ProverCredential{Expiry: now.Add(time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := tt.cred.Valid(now); valid != tt.valid {
+				t.Errorf("valid %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestStoreRestrictsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credential.json")
+	err := os.WriteFile(path, []byte("{}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &CredsClient{Cred: ProverCredential{AccessToken: "a"}, Path: path}
+	err = cc.store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("credential file mode %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/montanaflynn/stats v0.7.1
 	github.com/rs/zerolog v1.31.0
 	golang.org/x/crypto v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/didiercrunch/paillier v0.0.0-20180810105046-753322e473bf
@@ -28,6 +29,5 @@ require (
 	github.com/stretchr/testify v1.8.4 // indirect
 	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/sys v0.13.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	rsc.io/tmplfunc v0.0.3 // indirect
 )
@@ -1,10 +1,18 @@
 package main
 
 import (
+	p "client/policy"
 	pp "client/postprocess"
 	prv "client/prove"
 	r "client/request"
 	u "client/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"flag"
@@ -40,17 +48,46 @@ func main() {
 	// check for -stats flag
 	stats := flag.Bool("stats", false, "measures file sizes of zk and transcript files.")
 
+	// policy file or bundle directory, -policy-name selects a policy of a bundle
+	policyPath := flag.String("policy", "", "path to the policy file or policy bundle directory. defaults to "+p.DefaultPath+" or the embedded policy.")
+	policyName := flag.String("policy-name", "", "name of the policy in the policy bundle directory.")
+
+	// check for -validate-policy flag
+	validatePolicy := flag.Bool("validate-policy", false, "validates the policy file against the policy format and returns.")
+
 	// Set Server parameters
 	serverDomain := flag.String("serverdomain", "", "URL of the proxy server")
 	serverEndpoint := flag.String("serverendpoint", "", "URL of the proxy server")
 
+	// request spec file replaces the server and request flags
+	specPath := flag.String("spec", "", "path to a yaml or json request spec describing url, method, headers, body, credential and policy.")
+
+	// Set request method and body
+	method := flag.String("method", "GET", "http method of the request, e.g. GET, POST or PUT.")
+	body := flag.String("body", "", "request body sent with POST or PUT requests.")
+	bodyFile := flag.String("body-file", "", "path to a file holding the request body, replaces -body.")
+	contentType := flag.String("content-type", "application/json", "content type of the request body.")
+	curves := flag.String("curves", "", "comma separated key exchange groups in order of preference, e.g. X25519,P256, defaults to P256.")
+	dialTimeout := flag.Duration("dial-timeout", r.DefaultDialTimeout, "timeout of the tcp connection to the proxy, 0 disables it.")
+	handshakeTimeout := flag.Duration("handshake-timeout", r.DefaultHandshakeTimeout, "timeout of the tls handshake with the server, 0 disables it.")
+	readTimeout := flag.Duration("read-timeout", r.DefaultReadTimeout, "timeout of each request-response exchange, 0 disables it.")
+	maxRedirects := flag.Int("max-redirects", 0, "maximum number of followed redirects, 0 disables following. redirects to another host open a new proxied session.")
+	serverPort := flag.Int("port", 0, "port of the server, defaults to 443.")
+	caBundle := flag.String("ca-bundle", "", "pem bundle of trusted ca certificates, e.g. certs/certificates/ca.crt for the local test server.")
+	noSystemRoots := flag.Bool("no-system-roots", false, "trusts only the certificates of -ca-bundle instead of the system pool as well.")
+	pinSPKI := flag.String("pin-spki", "", "comma separated base64 sha256 hashes of SubjectPublicKeyInfos, one must match the server chain.")
+	clientCert := flag.String("client-cert", "", "pem file of the client certificate presented if the server requests client authentication, e.g. certs/certificates/prover.pem.")
+	clientKey := flag.String("client-key", "", "pem file of the client certificate key, e.g. certs/certificates/prover.key.")
+	headersFile := flag.String("headers", "", "path to a json object of request headers, values may reference environment variables as ${NAME}.")
+
 	// Set Proxy URL's
 	proxyListenerURL := flag.String("proxylistener", "", "URL of the proxy server")
 	proxyServerURL := flag.String("proxyserver", "", "URL of the proxy server")
 
 	flag.Parse()
 
-	if *proxyServerURL == "" {
+	// policy validation runs without proxy
+	if *proxyServerURL == "" && !*validatePolicy {
 		log.Error().Msg("proxyServerURL not set. Please provide the -proxyserver flag.")
 		return
 	}
@@ -66,6 +103,38 @@ func main() {
 		log.Trace().Msg("Debugging activated.")
 	}
 
+	// request spec
+	var spec r.Spec
+	if *specPath != "" {
+		if *serverDomain != "" || *serverEndpoint != "" {
+			log.Error().Msg("-spec replaces the -serverdomain and -serverendpoint flags.")
+			return
+		}
+		var err error
+		spec, err = r.LoadSpec(*specPath)
+		if err != nil {
+			log.Error().Err(err).Msg("r.LoadSpec")
+			return
+		}
+		// policy flags take precedence over the spec
+		if *policyPath == "" && *policyName == "" {
+			*policyPath = spec.Path(spec.Policy)
+			*policyName = spec.PolicyName
+		}
+	}
+
+	// policy used by postprocessing
+	policy, err := loadPolicy(*policyPath, *policyName)
+
+	if *validatePolicy {
+		if err != nil {
+			fmt.Println("policy invalid:", err)
+			os.Exit(1)
+		}
+		fmt.Println("policy valid.")
+		return
+	}
+
 	if *request {
 
 		if *proxyListenerURL == "" {
@@ -73,11 +142,90 @@ func main() {
 			return
 		}
 
+		if err != nil {
+			log.Error().Err(err).Msg("loadPolicy")
+			return
+		}
+
+		requestBody, err := readRequestBody(*body, *bodyFile)
+		if err != nil {
+			log.Error().Err(err).Msg("readRequestBody")
+			return
+		}
+
 		startTime := time.Now()
 
-		handleRequest(*hsonly, *serverDomain, *serverEndpoint, *proxyListenerURL)
-		handlePostProcessKDC()
-		handlePostProcessRecord()
+		// outputs of an earlier session must not be sent for this one
+		for _, name := range []string{"server_identity", "sf_public", "recordtag_public_input", "recorddata_public_input", "recorddata_private_input"} {
+			err = u.Remove(name)
+			if err != nil {
+				log.Error().Err(err).Str("file", name).Msg("u.Remove")
+				return
+			}
+		}
+
+		req := r.NewRequest(*serverDomain, *serverEndpoint, *proxyListenerURL)
+		if *specPath != "" {
+			req, err = spec.NewRequest(*proxyListenerURL)
+			if err != nil {
+				log.Error().Err(err).Msg("spec.NewRequest")
+				return
+			}
+		} else {
+			req.Method = strings.ToUpper(*method)
+			req.Body = requestBody
+			req.ContentType = *contentType
+		}
+		req.DialTimeout = *dialTimeout
+		req.HandshakeTimeout = *handshakeTimeout
+		req.ReadTimeout = *readTimeout
+		if *serverPort != 0 {
+			req.ServerPort = *serverPort
+		}
+		if *caBundle != "" {
+			req.CABundle = *caBundle
+		}
+		if *noSystemRoots {
+			req.SystemRoots = false
+		}
+		if *pinSPKI != "" {
+			req.PinnedSPKI = r.SplitNames(*pinSPKI)
+		}
+		if *clientCert != "" || *clientKey != "" {
+			req.ClientCertificate = *clientCert
+			req.ClientKey = *clientKey
+		}
+		if *maxRedirects > 0 {
+			req.MaxRedirects = *maxRedirects
+		}
+		if *curves != "" {
+			req.Curves = r.SplitNames(*curves)
+		}
+		if *headersFile != "" {
+			headers, err := r.LoadHeaders(*headersFile)
+			if err != nil {
+				log.Error().Err(err).Msg("r.LoadHeaders")
+				return
+			}
+			for name, value := range headers {
+				req.Headers[name] = value
+			}
+		}
+		// interrupts cancel the call
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = handleRequest(ctx, *hsonly, req)
+		stop()
+		if err != nil {
+			return
+		}
+		err = handlePostProcessKDC()
+		if err != nil {
+			return
+		}
+		err = handlePostProcessRecord(policy)
+		if err != nil {
+			return
+		}
 
 		// Prepare data to be sent
 		kdcShared, err := u.ReadJSONFile("local_storage/kdc_shared.json")
@@ -101,11 +249,31 @@ func main() {
 			return
 		}
 
+		sfPublic, err := u.ReadJSONFile("local_storage/sf_public.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read sf_public.json")
+			return
+		}
+		serverIdentity, err := u.ReadJSONFile("local_storage/server_identity.json")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read server_identity.json")
+			return
+		}
+
+		policyCommitment, err := policy.Commitment()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to compute policy commitment")
+			return
+		}
+
 		combinedData := &u.CombinedData{
 			KDCShared:        kdcShared,
 			RecordTagPublic:  recordTagPublic,
 			RecordDataPublic: recordDataPublic,
 			KDCPublicInput:   kdcPublicInput,
+			ServerIdentity:   serverIdentity,
+			SFPublic:         sfPublic,
+			PolicyCommitment: policyCommitment,
 		}
 
 		err = u.SendCombinedDataToProxy("postprocess", *proxyServerURL, combinedData)
@@ -190,34 +358,78 @@ func main() {
 	}
 }
 
-func handleRequest(hsonly bool, serverDomain string, serverEndpoint string, proxyListenerURL string) {
-	req := r.NewRequest(serverDomain, serverEndpoint, proxyListenerURL)
-	data, err := req.Call(hsonly)
+// loadPolicy loads a policy of a bundle directory if name is set,
+// the policy file at path otherwise or the default policy
+func loadPolicy(path string, name string) (p.Policy, error) {
+	switch {
+	case name != "":
+		if path == "" {
+			path = filepath.Dir(p.DefaultPath)
+		}
+		return p.LoadBundle(path, name)
+	case path != "":
+		return p.Load(path)
+	}
+	return p.New()
+}
+
+// readRequestBody returns the request body given inline or by file
+func readRequestBody(body string, bodyFile string) ([]byte, error) {
+	if bodyFile != "" {
+		return os.ReadFile(bodyFile)
+	}
+	if body == "" {
+		return nil, nil
+	}
+	return []byte(body), nil
+}
+
+func handleRequest(ctx context.Context, hsonly bool, req r.RequestTLS) error {
+	data, err := req.CallContext(ctx, hsonly)
 	if err != nil {
-		log.Error().Msg("req.Call()")
+		switch {
+		case errors.Is(err, r.ErrProxyTimeout):
+			log.Error().Err(err).Msg("proxy did not respond in time.")
+		case errors.Is(err, r.ErrSessionTimeout):
+			log.Error().Err(err).Msg("proxied session stalled, the proxy did not relay or the server did not respond in time.")
+		default:
+			log.Error().Msg("req.CallContext()")
+		}
+		return err
 	}
 	if !hsonly {
 		err = req.Store(data)
 		if err != nil {
 			log.Error().Msg("req.Store(data)")
+			return err
 		}
 	}
+	return nil
 }
 
-func handlePostProcessKDC() {
+func handlePostProcessKDC() error {
 	start := time.Now()
 
 	// read in session data
 	toBshared, err := pp.Read()
 	if err != nil {
-		log.Error().Msg("pp.Compute()")
+		log.Error().Err(err).Msg("pp.Read()")
+		return err
 	}
 
-	// // derive public data necessary to verify SF and server certificate
-	// err = pp.ProcessSF(toBshared)
-	// if err != nil {
-	// 	log.Error().Msg("pp.ProcessSF(toBshared)")
-	// }
+	// server certificate chain bound to the handshake transcript
+	err = pp.ServerIdentity(toBshared)
+	if err != nil {
+		log.Error().Err(err).Msg("pp.ServerIdentity(toBshared)")
+		return err
+	}
+
+	// derive public data necessary to verify SF and server certificate
+	err = pp.ProcessSF(toBshared)
+	if err != nil {
+		log.Error().Err(err).Msg("pp.ProcessSF(toBshared)")
+		return err
+	}
 
 	// derive public data necessary to derive the server application traffic key and iv
 	sdataMap, err := pp.DeriveKeyIvSATS(toBshared)
@@ -250,9 +462,10 @@ func handlePostProcessKDC() {
 
 	elapsed := time.Since(start)
 	log.Debug().Str("elapsed", elapsed.String()).Msg("postprocess_kdc time.")
+	return nil
 }
 
-func handlePostProcessRecord() {
+func handlePostProcessRecord(policy p.Policy) error {
 
 	start := time.Now() // Add this line
 
@@ -260,7 +473,8 @@ func handlePostProcessRecord() {
 	/////////////////////
 	recordPerSequence, err := pp.ReadServerRecords()
 	if err != nil {
-		log.Error().Msg("pp.ReadServerRecords")
+		log.Error().Err(err).Msg("pp.ReadServerRecords")
+		return err
 	}
 
 	// prints record data
@@ -268,21 +482,28 @@ func handlePostProcessRecord() {
 
 	sParams, err := pp.ReadServerParams()
 	if err != nil {
-		log.Error().Msg("pp.ReadServerRecords")
+		log.Error().Err(err).Msg("pp.ReadServerParams")
+		return err
 	}
 
 	// generates recordtag_public_input.json
 	// no private input stored because private input (iv, key) must be derived in circuit
 	// important: sequence number used as public input to compute on right record
-	pp.RecordTagZkInput(sParams, recordPerSequence)
+	err = pp.RecordTagZkInput(sParams, recordPerSequence)
+	if err != nil {
+		log.Error().Err(err).Msg("pp.RecordTagZkInput")
+		return err
+	}
 
 	// policy based public input extraction for record layer data
 	// stores parameters in recorddata_public_input.json
-	err = pp.ParsePlaintextWithPolicy(recordPerSequence)
+	err = pp.ParsePlaintextWithPolicy(recordPerSequence, policy)
 	if err != nil {
-		log.Error().Msg("pp.ParsePlaintextWithPolicy")
+		log.Error().Err(err).Msg("pp.ParsePlaintextWithPolicy")
+		return err
 	}
 
 	elapsed := time.Since(start)
 	log.Debug().Str("elapsed", elapsed.String()).Msg("postprocess_record time.")
+	return nil
 }
@@ -0,0 +1,81 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Canonical returns the canonical json serialization of the policy.
+// Keys are sorted, zero values are omitted and no whitespace is emitted,
+// so that equal policies serialize to equal bytes independent of formatting.
+func (p Policy) Canonical() ([]byte, error) {
+
+	// struct to generic map
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// maps are marshalled with sorted keys
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(canonicalValue(raw))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Commitment returns the hex encoded sha256 hash of the canonical policy
+func (p Policy) Commitment() (string, error) {
+	data, err := p.Canonical()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// canonicalValue drops object members with zero values recursively
+func canonicalValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for k, inner := range value {
+			if !isZero(inner) {
+				m[k] = canonicalValue(inner)
+			}
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, inner := range value {
+			list[i] = canonicalValue(inner)
+		}
+		return list
+	}
+	return v
+}
+
+// isZero reports whether a json value equals the zero value of its go type
+func isZero(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case float64:
+		return value == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		canonical string
+	}{
+		{"sorted keys and zero values omitted", `{"version": 1, "value_constraint": "GT", "threshold_value": "30001", "selector": "$.price", "scale": 0}`,
+			`{"selector":"$.price","threshold_value":"30001","value_constraint":"GT","version":1}`},
+		{"predicates keep their order", `{"combinator": "OR", "predicates": [
+			{"selector": "$.b", "threshold_value": "2", "value_constraint": "LT"},
+			{"selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}]}`,
+			`{"combinator":"OR","predicates":[{"selector":"$.b","threshold_value":"2","value_constraint":"LT"},{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}]}`},
+		{"html characters are not escaped", `{"substring": "\"a<b>&\":", "value_start_idx_after_ss": 1, "threshold_value": "1", "value_constraint": "GT"}`,
+			`{"substring":"\"a<b>&\":","threshold_value":"1","value_constraint":"GT","value_start_idx_after_ss":1}`},
+		{"set members", `{"selector": "$.a", "value_constraint": "IN", "values": ["20", "10"]}`,
+			`{"selector":"$.a","value_constraint":"IN","values":["20","10"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := FromBytes([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("FromBytes: %v", err)
+			}
+			canonical, err := policy.Canonical()
+			if err != nil {
+				t.Fatalf("Canonical: %v", err)
+			}
+			if string(canonical) != tt.canonical {
+				t.Errorf("canonical\n%s\nwant\n%s", canonical, tt.canonical)
+			}
+		})
+	}
+}
+
+func TestCommitment(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{"formatting", `{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}`,
+			"{\n  \"value_constraint\": \"GT\",\n  \"selector\": \"$.a\",\n  \"threshold_value\": \"1\"\n}", true},
+		{"explicit zero values", `{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}`,
+			`{"selector":"$.a","threshold_value":"1","value_constraint":"GT","exchange":0,"value_type":""}`, true},
+		{"threshold", `{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}`,
+			`{"selector":"$.a","threshold_value":"2","value_constraint":"GT"}`, false},
+		{"operator", `{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}`,
+			`{"selector":"$.a","threshold_value":"1","value_constraint":"GE"}`, false},
+		{"predicate order", `{"predicates":[{"selector":"$.a","threshold_value":"1","value_constraint":"GT"},{"selector":"$.b","threshold_value":"1","value_constraint":"GT"}]}`,
+			`{"predicates":[{"selector":"$.b","threshold_value":"1","value_constraint":"GT"},{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}]}`, false},
+		{"combinator", `{"predicates":[{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}]}`,
+			`{"combinator":"OR","predicates":[{"selector":"$.a","threshold_value":"1","value_constraint":"GT"}]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var commitments [2]string
+			for i, data := range []string{tt.a, tt.b} {
+				policy, err := FromBytes([]byte(data))
+				if err != nil {
+					t.Fatalf("FromBytes: %v", err)
+				}
+				commitments[i], err = policy.Commitment()
+				if err != nil {
+					t.Fatalf("Commitment: %v", err)
+				}
+				if len(commitments[i]) != 64 {
+					t.Fatalf("commitment %q is no hex encoded sha256 hash", commitments[i])
+				}
+			}
+			if (commitments[0] == commitments[1]) != tt.equal {
+				t.Errorf("commitments %s and %s, want equal %v", commitments[0], commitments[1], tt.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supported date and time formats and their go time layouts,
+// all formats order digits from most to least significant
+// so that the digits compare as integers
+var dateLayouts = map[string]string{
+	"YYYY-MM-DD":           "2006-01-02",
+	"YYYY.MM.DD":           "2006.01.02",
+	"YYYYMMDD":             "20060102",
+	"hh:mm:ss":             "15:04:05",
+	"YYYY-MM-DDThh:mm:ssZ": "2006-01-02T15:04:05Z",
+	"unix":                 "",
+}
+
+// date formats allowed per value type, the first one is the default
+var typeDateFormats = map[string][]string{
+	TypeDate:      {"YYYY-MM-DD", "YYYY.MM.DD", "YYYYMMDD"},
+	TypeTime:      {"hh:mm:ss"},
+	TypeTimestamp: {"unix", "YYYY-MM-DDThh:mm:ssZ"},
+}
+
+// dateType reports whether the policy value is a date or time
+func (p Policy) dateType() bool {
+	_, ok := typeDateFormats[p.Type()]
+	return ok
+}
+
+// Format returns the date format of the policy or the default format of the value type
+func (p Policy) Format() string {
+	if p.DateFormat != "" {
+		return p.DateFormat
+	}
+	if formats, ok := typeDateFormats[p.Type()]; ok {
+		return formats[0]
+	}
+	return ""
+}
+
+// dateSeparator reports whether b is a non digit byte of the policy date format
+func (p Policy) dateSeparator(b byte) bool {
+	layout := dateLayouts[p.Format()]
+	return layout != "" && (b < '0' || b > '9') && strings.IndexByte(layout, b) >= 0
+}
+
+// normalizeDate strips the separators of a date or time value and returns
+// its digits as comparable integer and the skipped byte offsets
+func (p Policy) normalizeDate(raw string) (string, []int, error) {
+
+	layout, ok := dateLayouts[p.Format()]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown date_format %q", p.Format())
+	}
+
+	// unix timestamps are plain integers
+	if layout == "" {
+		_, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("value %q is not a unix timestamp", raw)
+		}
+		return raw, []int{}, nil
+	}
+
+	_, err := time.Parse(layout, raw)
+	if err != nil || len(raw) != len(layout) {
+		return "", nil, fmt.Errorf("value %q does not match date_format %q", raw, p.Format())
+	}
+
+	var digits strings.Builder
+	skip := []int{}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] >= '0' && raw[i] <= '9' {
+			digits.WriteByte(raw[i])
+		} else {
+			skip = append(skip, i)
+		}
+	}
+	return digits.String(), skip, nil
+}
+
+// freshnessThreshold returns the oldest accepted timestamp
+// of policies with max_age in the policy date format
+func (p Policy) freshnessThreshold(now time.Time) string {
+	oldest := now.Add(-time.Duration(p.MaxAge) * time.Second).UTC()
+	layout := dateLayouts[p.Format()]
+	if layout == "" {
+		return strconv.FormatInt(oldest.Unix(), 10)
+	}
+	return oldest.Format(layout)
+}
+
+// FreshnessTolerance bounds the clock difference between prover and verifier
+// accepted for the freshness threshold of policies with max_age
+const FreshnessTolerance = 5 * time.Minute
+
+// CheckFreshness verifies the public freshness threshold of a proof against the
+// verifier clock, the threshold is chosen by the prover and must not lie more
+// than the tolerance away from now minus max_age
+func (p Policy) CheckFreshness(threshold string, now time.Time, tolerance time.Duration) error {
+
+	if p.MaxAge <= 0 {
+		return errors.New("policy has no max_age")
+	}
+	value, ok := new(big.Int).SetString(threshold, 10)
+	if !ok {
+		return fmt.Errorf("invalid freshness threshold %q", threshold)
+	}
+
+	// normalized thresholds grow with time in all timestamp formats
+	lower, err := p.NormalizeNumber(p.freshnessThreshold(now.Add(-tolerance)))
+	if err != nil {
+		return err
+	}
+	upper, err := p.NormalizeNumber(p.freshnessThreshold(now.Add(tolerance)))
+	if err != nil {
+		return err
+	}
+	if value.Cmp(bigInt(lower)) < 0 || value.Cmp(bigInt(upper)) > 0 {
+		return fmt.Errorf("freshness threshold %s outside of [%s,%s]", threshold, lower, upper)
+	}
+	return nil
+}
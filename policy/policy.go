@@ -1,41 +1,153 @@
 package policy
 
 import (
-	"encoding/json"
+	_ "embed"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
 
 type Policy struct {
+	Version              int    `json:"version"`
+	Selector             string `json:"selector"`
 	Substring            string `json:"substring"`
 	ValueStartIdxAfterSS int    `json:"value_start_idx_after_ss"`
 	ValueLength          int    `json:"value_length"`
 	ThresholdValue       string `json:"threshold_value"`
 	ValueConstraint      string `json:"value_constraint"`
+	ValueType            string `json:"value_type"`
+	Scale                int    `json:"scale"`
+	ThousandsSeparator   string `json:"thousands_separator"`
+	DateFormat           string `json:"date_format"`
+	// maximum age in seconds of timestamp values, compared against now
+	MaxAge int `json:"max_age"`
+	// index of the request-response exchange of the session holding the value
+	Exchange int `json:"exchange"`
+	// inclusive bounds of BETWEEN constraints
+	LowerValue string `json:"lower_value"`
+	UpperValue string `json:"upper_value"`
+	// set members of IN and NOT_IN constraints
+	Values []string `json:"values"`
+	// expected string of string and bool constraints, either in clear
+	// or as hex encoded sha256 hash which keeps the string private
+	ExpectedValue string `json:"expected_value"`
+	ExpectedHash  string `json:"expected_hash"`
+	// length of the hashed prefix or suffix of STARTS_WITH and ENDS_WITH
+	ExpectedLength int `json:"expected_length"`
+	// compound policies combine the listed predicates
+	Combinator string   `json:"combinator"`
+	Predicates []Policy `json:"predicates"`
 }
 
+// policy combinators
+const (
+	CombinatorAnd = "AND"
+	CombinatorOr  = "OR"
+)
+
+// default policy compiled into the binary
+//
+//go:embed policy.json
+var defaultPolicy []byte
+
+// DefaultPath is the policy file used if no path is configured
+const DefaultPath = "policy/policy.json"
+
+// New loads the policy at DefaultPath relative to the working directory
+// and falls back to the embedded default policy if the file does not exist
 func New() (Policy, error) {
+	_, err := os.Stat(DefaultPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debug().Msg("using embedded default policy")
+		return FromBytes(defaultPolicy)
+	}
+	return Load(DefaultPath)
+}
+
+// Load reads and validates the policy file at path
+func Load(path string) (Policy, error) {
 	// open file
-	file, err := os.Open("policy/policy.json")
+	file, err := os.Open(path)
 	if err != nil {
 		log.Error().Err(err).Msg("os.Open")
 		return Policy{}, err
 	}
 	defer file.Close()
+	// bundles require a policy name
+	info, err := file.Stat()
+	if err != nil {
+		log.Error().Err(err).Msg("file.Stat()")
+		return Policy{}, err
+	}
+	if info.IsDir() {
+		return Policy{}, fmt.Errorf("policy path %s is a directory, select a policy of the bundle by name", path)
+	}
 	// read in data
 	data, err := io.ReadAll(file)
 	if err != nil {
 		log.Error().Err(err).Msg("io.ReadAll(file)")
 		return Policy{}, err
 	}
-	// parse json into policy struct
-	var policy Policy
-	err = json.Unmarshal(data, &policy)
+	return FromBytes(data)
+}
+
+// LoadBundle loads the policy with the given name from a bundle directory,
+// a bundle holds named policies side by side as <name>.json
+func LoadBundle(dir string, name string) (Policy, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return Policy{}, fmt.Errorf("invalid policy name %q", name)
+	}
+	path := filepath.Join(dir, name+".json")
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		names, _ := BundleNames(dir)
+		return Policy{}, fmt.Errorf("policy %q not found in bundle %s, available: %s", name, dir, strings.Join(names, ", "))
+	}
+	return Load(path)
+}
+
+// BundleNames lists the names of all policies in a bundle directory
+func BundleNames(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	return names, nil
+}
+
+// FromBytes parses and validates policy data against the policy format
+func FromBytes(data []byte) (Policy, error) {
+	policy, err := Validate(data)
 	if err != nil {
-		log.Error().Err(err).Msg("json.Unmarshal(data, &objmap)")
+		log.Error().Err(err).Msg("Validate(data)")
 		return Policy{}, err
 	}
 	return policy, nil
 }
+
+// Flatten returns the predicates of a compound policy
+// or the policy itself if it holds a single predicate
+func (p Policy) Flatten() []Policy {
+	if len(p.Predicates) == 0 {
+		return []Policy{p}
+	}
+	return p.Predicates
+}
+
+// CombinatorOrDefault returns the policy combinator, predicates are combined with AND by default
+func (p Policy) CombinatorOrDefault() string {
+	if p.Combinator == "" {
+		return CombinatorAnd
+	}
+	return p.Combinator
+}
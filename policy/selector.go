@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment is a single step of a policy selector,
+// either an object key or an array index
+type Segment struct {
+	Key   string
+	Index int
+	// true if the segment selects an array element
+	IsIndex bool
+}
+
+// Segments parses the policy selector, e.g. "$.personal data.income" or "$.items[0].price".
+// Keys are separated by dots and may contain spaces, array elements are selected with [n].
+func (p Policy) Segments() ([]Segment, error) {
+
+	if !strings.HasPrefix(p.Selector, "$.") {
+		return nil, fmt.Errorf("selector %q must start with \"$.\"", p.Selector)
+	}
+
+	var segments []Segment
+	for _, part := range strings.Split(p.Selector[2:], ".") {
+
+		// split key from trailing array indices
+		key := part
+		var indices []int
+		for strings.HasSuffix(key, "]") {
+			open := strings.LastIndex(key, "[")
+			if open < 0 {
+				return nil, fmt.Errorf("selector %q has unbalanced brackets", p.Selector)
+			}
+			idx, err := strconv.Atoi(key[open+1 : len(key)-1])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("selector %q has invalid array index %q", p.Selector, key[open+1:len(key)-1])
+			}
+			indices = append([]int{idx}, indices...)
+			key = key[:open]
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("selector %q has empty key", p.Selector)
+		}
+		segments = append(segments, Segment{Key: key})
+		for _, idx := range indices {
+			segments = append(segments, Segment{Index: idx, IsIndex: true})
+		}
+	}
+
+	// the circuit matches the last key, so the value must belong to an object key
+	if segments[len(segments)-1].IsIndex {
+		return nil, fmt.Errorf("selector %q must end with an object key", p.Selector)
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Expected returns the length of the expected string
+// and the expected bytes, which are nil if only the hash is known
+func (p Policy) Expected() (int, []byte, error) {
+	if p.ExpectedHash == "" {
+		return len(p.ExpectedValue), []byte(p.ExpectedValue), nil
+	}
+	hash, err := hex.DecodeString(p.ExpectedHash)
+	if err != nil || len(hash) != sha256.Size {
+		return 0, nil, errors.New("expected_hash must be a hex encoded sha256 hash")
+	}
+	return p.ExpectedLength, nil, nil
+}
+
+// MatchRange returns the plaintext byte offsets of the value bytes
+// compared against the expected string, end is exclusive
+func (p Policy) MatchRange(v Value) (int, int, error) {
+
+	length, _, err := p.Expected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch p.ValueConstraint {
+	case "EQ", "NE":
+		return v.Start, v.End, nil
+	case "STARTS_WITH":
+		if length > v.End-v.Start {
+			return 0, 0, fmt.Errorf("expected prefix of %d bytes is longer than value", length)
+		}
+		return v.Start, v.Start + length, nil
+	case "ENDS_WITH":
+		if length > v.End-v.Start {
+			return 0, 0, fmt.Errorf("expected suffix of %d bytes is longer than value", length)
+		}
+		return v.End - length, v.End, nil
+	}
+	return 0, 0, fmt.Errorf("no string comparison for value_constraint %q", p.ValueConstraint)
+}
+
+// holdsText evaluates string and bool constraints on the raw value bytes
+func (p Policy) holdsText(v Value) (bool, error) {
+
+	start, end, err := p.MatchRange(v)
+	if err != nil {
+		// prefix or suffix longer than the value
+		if p.ValueConstraint == "STARTS_WITH" || p.ValueConstraint == "ENDS_WITH" {
+			return false, nil
+		}
+		return false, err
+	}
+	matched := v.Text[start-v.Start : end-v.Start]
+
+	// compare in clear or by hash
+	equal := matched == p.ExpectedValue
+	if p.ExpectedHash != "" {
+		hash := sha256.Sum256([]byte(matched))
+		equal = strings.EqualFold(hex.EncodeToString(hash[:]), p.ExpectedHash)
+	}
+
+	if p.ValueConstraint == "NE" {
+		return !equal, nil
+	}
+	return equal, nil
+}
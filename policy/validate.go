@@ -0,0 +1,456 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// FormatVersion is the version of the policy format written by policy authors,
+// each version defines the fields a policy may contain
+const FormatVersion = 1
+
+// FieldError points to the policy field which failed validation
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return "policy field " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// kinds of json values of the policy fields
+const (
+	kindString = iota
+	kindInt
+	kindStrings
+	kindPredicates
+)
+
+// fields allowed in a predicate per format version
+var predicateFields = map[int]map[string]int{
+	1: {
+		"selector":                 kindString,
+		"substring":                kindString,
+		"value_start_idx_after_ss": kindInt,
+		"value_length":             kindInt,
+		"threshold_value":          kindString,
+		"value_constraint":         kindString,
+		"value_type":               kindString,
+		"scale":                    kindInt,
+		"thousands_separator":      kindString,
+		"date_format":              kindString,
+		"max_age":                  kindInt,
+		"exchange":                 kindInt,
+		"lower_value":              kindString,
+		"upper_value":              kindString,
+		"values":                   kindStrings,
+		"expected_value":           kindString,
+		"expected_hash":            kindString,
+		"expected_length":          kindInt,
+	},
+}
+
+// fields allowed only at the top level of a policy per format version
+var policyFields = map[int]map[string]int{
+	1: {
+		"version":    kindInt,
+		"combinator": kindString,
+		"predicates": kindPredicates,
+	},
+}
+
+// plaintext bytes per record the record data circuit decrypts,
+// key, value and the byte terminating the value must fit into them
+const CircuitBytes = 64
+
+// MaxScale bounds the fractional digits of decimal values, so that values
+// filling the circuit bytes stay below the scalar field of the circuit
+const MaxScale = 16
+
+// value constraints supported per value type
+var typeOperators = map[string][]string{
+	TypeInt:       {"GT", "LT", "EQ", "GE", "LE", "NE", "BETWEEN", "IN", "NOT_IN"},
+	TypeDecimal:   {"GT", "LT", "EQ", "GE", "LE", "NE", "BETWEEN", "IN", "NOT_IN"},
+	TypeDate:      {"GT", "LT", "EQ", "GE", "LE", "NE", "BETWEEN", "IN", "NOT_IN"},
+	TypeTime:      {"GT", "LT", "EQ", "GE", "LE", "NE", "BETWEEN", "IN", "NOT_IN"},
+	TypeTimestamp: {"GT", "LT", "EQ", "GE", "LE", "NE", "BETWEEN", "IN", "NOT_IN"},
+	TypeString:    {"EQ", "NE", "STARTS_WITH", "ENDS_WITH"},
+	TypeBool:      {"EQ", "NE"},
+}
+
+// Validate parses policy data and checks it against the fields of its format version.
+// Unknown fields and operators which do not fit the value type are rejected.
+func Validate(data []byte) (Policy, error) {
+
+	// parse raw json to check fields before decoding
+	var raw map[string]interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return Policy{}, &FieldError{Field: "$", Err: err}
+	}
+
+	// format version, policies without version use the first format
+	version := float64(1)
+	if v, ok := raw["version"]; ok {
+		version, ok = v.(float64)
+		if !ok {
+			return Policy{}, &FieldError{Field: "version", Err: errors.New("not a number")}
+		}
+	}
+	predicateKinds, ok := predicateFields[int(version)]
+	if !ok {
+		return Policy{}, &FieldError{Field: "version", Err: fmt.Errorf("unsupported format version %v, latest is %d", version, FormatVersion)}
+	}
+
+	// top level accepts predicate fields and compound fields
+	kinds := make(map[string]int)
+	for k, v := range predicateKinds {
+		kinds[k] = v
+	}
+	for k, v := range policyFields[int(version)] {
+		kinds[k] = v
+	}
+	err = checkFields(raw, kinds, "")
+	if err != nil {
+		return Policy{}, err
+	}
+	if predicates, ok := raw["predicates"].([]interface{}); ok {
+		for i, predicate := range predicates {
+			err = checkFields(predicate.(map[string]interface{}), predicateKinds, fmt.Sprintf("predicates[%d].", i))
+			if err != nil {
+				return Policy{}, err
+			}
+		}
+	}
+
+	// decode into policy struct
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&policy)
+	if err != nil {
+		return Policy{}, &FieldError{Field: "$", Err: err}
+	}
+
+	// compound policies hold no predicate fields at the top level
+	if len(policy.Predicates) > 0 {
+		for field := range predicateKinds {
+			if _, ok := raw[field]; ok {
+				return Policy{}, &FieldError{Field: field, Err: errors.New("not allowed next to predicates")}
+			}
+		}
+		switch policy.Combinator {
+		case "", CombinatorAnd, CombinatorOr:
+		default:
+			return Policy{}, &FieldError{Field: "combinator", Err: fmt.Errorf("unknown combinator %q", policy.Combinator)}
+		}
+		for i, predicate := range policy.Predicates {
+			err = predicate.validate(fmt.Sprintf("predicates[%d].", i))
+			if err != nil {
+				return Policy{}, err
+			}
+		}
+		return policy, nil
+	}
+
+	if _, ok := raw["combinator"]; ok {
+		return Policy{}, &FieldError{Field: "combinator", Err: errors.New("requires predicates")}
+	}
+	err = policy.validate("")
+	if err != nil {
+		return Policy{}, err
+	}
+	return policy, nil
+}
+
+// checkFields rejects unknown fields and values of the wrong kind
+func checkFields(raw map[string]interface{}, kinds map[string]int, prefix string) error {
+
+	// sorted for deterministic error reporting
+	fields := make([]string, 0, len(raw))
+	for field := range raw {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		kind, ok := kinds[field]
+		if !ok {
+			return &FieldError{Field: prefix + field, Err: errors.New("unknown field")}
+		}
+		value := raw[field]
+		valid := false
+		switch kind {
+		case kindString:
+			_, valid = value.(string)
+		case kindInt:
+			n, isNumber := value.(float64)
+			valid = isNumber && n == math.Trunc(n)
+		case kindStrings:
+			list, isList := value.([]interface{})
+			valid = isList
+			for _, v := range list {
+				if _, ok := v.(string); !ok {
+					valid = false
+				}
+			}
+		case kindPredicates:
+			list, isList := value.([]interface{})
+			valid = isList && len(list) > 0
+			for _, v := range list {
+				if _, ok := v.(map[string]interface{}); !ok {
+					valid = false
+				}
+			}
+		}
+		if !valid {
+			return &FieldError{Field: prefix + field, Err: errors.New("value has wrong type")}
+		}
+	}
+	return nil
+}
+
+// validate checks the semantics of a single predicate
+func (p Policy) validate(prefix string) error {
+
+	fieldErr := func(field string, format string, args ...interface{}) error {
+		return &FieldError{Field: prefix + field, Err: fmt.Errorf(format, args...)}
+	}
+
+	// value location
+	switch {
+	case p.Selector != "" && p.Substring != "":
+		return fieldErr("substring", "not allowed next to selector")
+	case p.Selector != "":
+		_, err := p.Segments()
+		if err != nil {
+			return fieldErr("selector", "%v", err)
+		}
+	case p.Substring != "":
+		if p.ValueStartIdxAfterSS < 1 {
+			return fieldErr("value_start_idx_after_ss", "must be at least 1")
+		}
+	default:
+		return fieldErr("selector", "selector or substring required")
+	}
+
+	if p.Exchange < 0 {
+		return fieldErr("exchange", "must not be negative")
+	}
+
+	// value type and operator
+	operators, ok := typeOperators[p.Type()]
+	if !ok {
+		return fieldErr("value_type", "unknown value type %q", p.ValueType)
+	}
+	supported := false
+	for _, operator := range operators {
+		if operator == p.ValueConstraint {
+			supported = true
+		}
+	}
+	if !supported {
+		return fieldErr("value_constraint", "operator %q not supported for value_type %q", p.ValueConstraint, p.Type())
+	}
+
+	// numeric format
+	if p.Scale < 0 || p.Scale > MaxScale {
+		return fieldErr("scale", "must be between 0 and %d", MaxScale)
+	}
+	if p.Scale > 0 && p.Type() != TypeDecimal {
+		return fieldErr("scale", "only allowed for value_type %q", TypeDecimal)
+	}
+	if p.ThousandsSeparator != "" {
+		if p.Type() != TypeInt && p.Type() != TypeDecimal {
+			return fieldErr("thousands_separator", "only allowed for value_type %q and %q", TypeInt, TypeDecimal)
+		}
+		if len(p.ThousandsSeparator) != 1 || p.ThousandsSeparator == "." || (p.ThousandsSeparator[0] >= '0' && p.ThousandsSeparator[0] <= '9') {
+			return fieldErr("thousands_separator", "must be a single non digit byte other than '.'")
+		}
+	}
+
+	// date format
+	if p.DateFormat != "" {
+		formats, ok := typeDateFormats[p.Type()]
+		if !ok {
+			return fieldErr("date_format", "only allowed for value_type %q, %q and %q", TypeDate, TypeTime, TypeTimestamp)
+		}
+		supported = false
+		for _, format := range formats {
+			if format == p.DateFormat {
+				supported = true
+			}
+		}
+		if !supported {
+			return fieldErr("date_format", "format %q not supported for value_type %q, use one of %s", p.DateFormat, p.Type(), strings.Join(formats, ", "))
+		}
+	}
+
+	// freshness replaces the threshold by now minus max_age
+	if p.MaxAge < 0 {
+		return fieldErr("max_age", "must not be negative")
+	}
+	if p.MaxAge > 0 {
+		if p.Type() != TypeTimestamp {
+			return fieldErr("max_age", "only allowed for value_type %q", TypeTimestamp)
+		}
+		if p.ValueConstraint != "GE" {
+			return fieldErr("max_age", "requires value_constraint GE")
+		}
+		if p.ThresholdValue != "" {
+			return fieldErr("threshold_value", "not allowed next to max_age")
+		}
+	}
+
+	// constraint values
+	if !p.Numeric() {
+		err := p.validateExpected(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case !p.Numeric():
+	case p.ValueConstraint == "BETWEEN":
+		if !validNumber(p, p.LowerValue) {
+			return fieldErr("lower_value", "invalid %s value %q", p.Type(), p.LowerValue)
+		}
+		if !validNumber(p, p.UpperValue) {
+			return fieldErr("upper_value", "invalid %s value %q", p.Type(), p.UpperValue)
+		}
+		lower, upper, _ := p.NormalizeBounds()
+		if bigInt(lower).Cmp(bigInt(upper)) > 0 {
+			return fieldErr("lower_value", "%s is greater than upper_value %s", p.LowerValue, p.UpperValue)
+		}
+	case p.ValueConstraint == "IN" || p.ValueConstraint == "NOT_IN":
+		if len(p.Values) == 0 {
+			return fieldErr("values", "%s requires at least one value", p.ValueConstraint)
+		}
+		for i, v := range p.Values {
+			if !validNumber(p, v) {
+				return fieldErr(fmt.Sprintf("values[%d]", i), "invalid %s value %q", p.Type(), v)
+			}
+		}
+	case p.MaxAge > 0:
+	default:
+		if !validNumber(p, p.ThresholdValue) {
+			return fieldErr("threshold_value", "invalid %s value %q", p.Type(), p.ThresholdValue)
+		}
+	}
+
+	// key and value must fit into the circuit bytes, postprocessing enforces
+	// the budget again on the actual chunk alignment of the record
+	if p.ValueLength < 0 {
+		return fieldErr("value_length", "must not be negative")
+	}
+	if p.ValueLength > CircuitBytes {
+		return fieldErr("value_length", "exceeds the %d bytes the circuit decrypts per record", CircuitBytes)
+	}
+	if span := p.span(); span > CircuitBytes {
+		field := "value_length"
+		if p.ValueLength == 0 {
+			field = "selector"
+			if p.Substring != "" {
+				field = "substring"
+			}
+		}
+		return fieldErr(field, "key and value span at least %d bytes, the circuit decrypts %d per record", span, CircuitBytes)
+	}
+
+	return nil
+}
+
+// span returns the minimum number of plaintext bytes from the key
+// to the byte terminating the value
+func (p Policy) span() int {
+
+	// quoted key and colon of selectors, the substring up to the value otherwise
+	key := len(p.Substring) + p.ValueStartIdxAfterSS - 1
+	if p.Selector != "" {
+		segments, _ := p.Segments()
+		key = len(segments[len(segments)-1].Key) + len(`"":`)
+		if p.Type() == TypeString {
+			key++
+		}
+	}
+
+	// shortest value the policy accepts
+	value := p.ValueLength
+	if value == 0 {
+		switch p.Type() {
+		case TypeString:
+			value = len(p.ExpectedValue)
+			if p.ExpectedLength > value {
+				value = p.ExpectedLength
+			}
+		case TypeBool:
+			value = len("true")
+		default:
+			value = 1
+		}
+	}
+	return key + value + 1
+}
+
+// validateExpected checks the expected string of string and bool constraints
+func (p Policy) validateExpected(prefix string) error {
+
+	fieldErr := func(field string, format string, args ...interface{}) error {
+		return &FieldError{Field: prefix + field, Err: fmt.Errorf(format, args...)}
+	}
+
+	if p.ThresholdValue != "" {
+		return fieldErr("threshold_value", "not allowed for value_type %q, use expected_value", p.Type())
+	}
+
+	switch {
+	case p.ExpectedValue != "" && p.ExpectedHash != "":
+		return fieldErr("expected_hash", "not allowed next to expected_value")
+	case p.ExpectedHash != "":
+		_, _, err := p.Expected()
+		if err != nil {
+			return fieldErr("expected_hash", "%v", err)
+		}
+		prefixOrSuffix := p.ValueConstraint == "STARTS_WITH" || p.ValueConstraint == "ENDS_WITH"
+		if prefixOrSuffix && p.ExpectedLength <= 0 {
+			return fieldErr("expected_length", "must be positive for hashed %s", p.ValueConstraint)
+		}
+		if !prefixOrSuffix && p.ExpectedLength != 0 {
+			return fieldErr("expected_length", "only allowed for hashed STARTS_WITH and ENDS_WITH")
+		}
+	case p.ExpectedValue != "":
+		// compared against raw json bytes
+		if strings.ContainsAny(p.ExpectedValue, "\"\\") {
+			return fieldErr("expected_value", "must not contain quotes or backslashes")
+		}
+		if p.ExpectedLength != 0 {
+			return fieldErr("expected_length", "only allowed with expected_hash")
+		}
+		if p.Type() == TypeBool && p.ExpectedValue != "true" && p.ExpectedValue != "false" {
+			return fieldErr("expected_value", "must be true or false for value_type %q", TypeBool)
+		}
+	default:
+		return fieldErr("expected_value", "expected_value or expected_hash required")
+	}
+
+	if p.ValueLength > 0 && (p.ExpectedLength > p.ValueLength || len(p.ExpectedValue) > p.ValueLength) {
+		return fieldErr("value_length", "shorter than expected string")
+	}
+	return nil
+}
+
+// validNumber reports whether raw is a valid numeric value of the policy type
+func validNumber(p Policy, raw string) bool {
+	_, err := p.NormalizeNumber(raw)
+	return err == nil
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// offending field, empty if the policy is valid
+		field string
+	}{
+		{"baseline policy without version", `{"substring": "\"price\"", "value_start_idx_after_ss": 3, "value_length": 5, "threshold_value": "30001", "value_constraint": "GT"}`, ""},
+		{"selector without value_length", `{"version": 1, "selector": "$.price", "threshold_value": "1", "value_constraint": "GT"}`, ""},
+		{"value filling the circuit bytes", `{"version": 1, "selector": "$.price", "value_length": 55, "threshold_value": "1", "value_constraint": "GT"}`, ""},
+		{"compound example", `{"version": 1, "combinator": "AND", "predicates": [
+			{"selector": "$.price", "value_type": "decimal", "scale": 1, "threshold_value": "30000", "value_constraint": "GT"},
+			{"selector": "$.personal data.age", "value_type": "int", "threshold_value": "18", "value_constraint": "GE"}]}`, ""},
+		{"compound without version", `{"combinator": "OR", "predicates": [{"selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}]}`, ""},
+		{"between", `{"selector": "$.age", "value_constraint": "BETWEEN", "lower_value": "18", "upper_value": "25"}`, ""},
+		{"in", `{"selector": "$.code", "value_constraint": "IN", "values": ["10", "20"]}`, ""},
+		{"string", `{"selector": "$.country", "value_type": "string", "value_constraint": "EQ", "expected_value": "DE"}`, ""},
+		{"hashed prefix", `{"selector": "$.iban", "value_type": "string", "value_constraint": "STARTS_WITH",
+			"expected_hash": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "expected_length": 3}`, ""},
+		{"date", `{"selector": "$.date", "value_type": "date", "date_format": "YYYY.MM.DD", "threshold_value": "2022.04.27", "value_constraint": "GE"}`, ""},
+		{"freshness", `{"selector": "$.ts", "value_type": "timestamp", "max_age": 3600, "value_constraint": "GE"}`, ""},
+
+		{"invalid json", `{"selector":`, "$"},
+		{"version not a number", `{"version": "1", "selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}`, "version"},
+		{"unsupported version", `{"version": 2, "selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}`, "version"},
+		{"unknown field", `{"selector": "$.a", "threshold": "1", "value_constraint": "GT"}`, "threshold"},
+		{"wrong type", `{"selector": "$.a", "threshold_value": 1, "value_constraint": "GT"}`, "threshold_value"},
+		{"fraction as int", `{"selector": "$.a", "value_length": 1.5, "threshold_value": "1", "value_constraint": "GT"}`, "value_length"},
+		{"unknown predicate field", `{"predicates": [{"selector": "$.a", "foo": 1}]}`, "predicates[0].foo"},
+		{"empty predicates", `{"predicates": []}`, "predicates"},
+		{"predicate field next to predicates", `{"selector": "$.a", "predicates": [{"selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}]}`, "selector"},
+		{"unknown combinator", `{"combinator": "XOR", "predicates": [{"selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}]}`, "combinator"},
+		{"combinator without predicates", `{"combinator": "AND", "selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}`, "combinator"},
+		{"invalid predicate", `{"predicates": [{"selector": "$.a", "threshold_value": "1", "value_constraint": "GT"}, {"selector": "$.b", "threshold_value": "1", "value_constraint": "LIKE"}]}`, "predicates[1].value_constraint"},
+		{"selector and substring", `{"selector": "$.a", "substring": "a", "threshold_value": "1", "value_constraint": "GT"}`, "substring"},
+		{"no location", `{"threshold_value": "1", "value_constraint": "GT"}`, "selector"},
+		{"invalid selector", `{"selector": "price", "threshold_value": "1", "value_constraint": "GT"}`, "selector"},
+		{"substring without value start", `{"substring": "a", "threshold_value": "1", "value_constraint": "GT"}`, "value_start_idx_after_ss"},
+		{"negative exchange", `{"selector": "$.a", "exchange": -1, "threshold_value": "1", "value_constraint": "GT"}`, "exchange"},
+		{"negative value_length", `{"selector": "$.a", "value_length": -1, "threshold_value": "1", "value_constraint": "GT"}`, "value_length"},
+		{"value_length beyond circuit bytes", `{"selector": "$.price", "value_length": 200, "threshold_value": "1", "value_constraint": "GT"}`, "value_length"},
+		{"key and value beyond circuit bytes", `{"selector": "$.price", "value_length": 56, "threshold_value": "1", "value_constraint": "GT"}`, "value_length"},
+		{"key beyond circuit bytes", `{"selector": "$.a_very_long_key_name_which_does_not_leave_any_room_for_its_value", "threshold_value": "1", "value_constraint": "GT"}`, "selector"},
+		{"substring beyond circuit bytes", `{"substring": "\"a_very_long_key_name_which_does_not_leave_room\"", "value_start_idx_after_ss": 20, "threshold_value": "1", "value_constraint": "GT"}`, "substring"},
+		{"expected string beyond circuit bytes", `{"selector": "$.a", "value_type": "string", "expected_value": "0123456789012345678901234567890123456789012345678901234567890", "value_constraint": "EQ"}`, "selector"},
+		{"unknown value type", `{"selector": "$.a", "value_type": "float", "threshold_value": "1", "value_constraint": "GT"}`, "value_type"},
+		{"operator of other type", `{"selector": "$.a", "value_type": "bool", "expected_value": "true", "value_constraint": "GT"}`, "value_constraint"},
+		{"scale beyond field", `{"selector": "$.a", "value_type": "decimal", "scale": 17, "threshold_value": "1", "value_constraint": "GT"}`, "scale"},
+		{"scale of int", `{"selector": "$.a", "scale": 2, "threshold_value": "1", "value_constraint": "GT"}`, "scale"},
+		{"digit separator", `{"selector": "$.a", "thousands_separator": "1", "threshold_value": "1", "value_constraint": "GT"}`, "thousands_separator"},
+		{"date format of int", `{"selector": "$.a", "date_format": "YYYYMMDD", "threshold_value": "1", "value_constraint": "GT"}`, "date_format"},
+		{"unknown date format", `{"selector": "$.a", "value_type": "date", "date_format": "DD.MM.YYYY", "threshold_value": "01.01.2022", "value_constraint": "GT"}`, "date_format"},
+		{"max_age of date", `{"selector": "$.a", "value_type": "date", "max_age": 10, "value_constraint": "GE"}`, "max_age"},
+		{"max_age with threshold", `{"selector": "$.a", "value_type": "timestamp", "max_age": 10, "threshold_value": "1", "value_constraint": "GE"}`, "threshold_value"},
+		{"invalid threshold", `{"selector": "$.a", "threshold_value": "1.5", "value_constraint": "GT"}`, "threshold_value"},
+		{"invalid date threshold", `{"selector": "$.a", "value_type": "date", "threshold_value": "2022-13-01", "value_constraint": "GT"}`, "threshold_value"},
+		{"inverted bounds", `{"selector": "$.a", "value_constraint": "BETWEEN", "lower_value": "25", "upper_value": "18"}`, "lower_value"},
+		{"empty set", `{"selector": "$.a", "value_constraint": "IN"}`, "values"},
+		{"invalid set member", `{"selector": "$.a", "value_constraint": "NOT_IN", "values": ["1", "x"]}`, "values[1]"},
+		{"threshold of string", `{"selector": "$.a", "value_type": "string", "threshold_value": "x", "value_constraint": "EQ"}`, "threshold_value"},
+		{"expected value and hash", `{"selector": "$.a", "value_type": "string", "expected_value": "x",
+			"expected_hash": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "value_constraint": "EQ"}`, "expected_hash"},
+		{"quote in expected value", `{"selector": "$.a", "value_type": "string", "expected_value": "a\"b", "value_constraint": "EQ"}`, "expected_value"},
+		{"hashed prefix without length", `{"selector": "$.a", "value_type": "string", "value_constraint": "ENDS_WITH",
+			"expected_hash": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}`, "expected_length"},
+		{"invalid bool", `{"selector": "$.a", "value_type": "bool", "expected_value": "yes", "value_constraint": "EQ"}`, "expected_value"},
+		{"expected longer than value_length", `{"selector": "$.a", "value_type": "string", "value_length": 1, "expected_value": "DE", "value_constraint": "EQ"}`, "value_length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Validate([]byte(tt.data))
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("expected field error on %s, got %v", tt.field, err)
+			}
+			if fieldErr.Field != tt.field {
+				t.Errorf("error on field %s, want %s: %v", fieldErr.Field, tt.field, err)
+			}
+		})
+	}
+}
+
+func TestValidateShippedPolicies(t *testing.T) {
+	_, err := Validate(defaultPolicy)
+	if err != nil {
+		t.Errorf("embedded default policy: %v", err)
+	}
+	data, err := os.ReadFile("policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Validate(data)
+	if err != nil {
+		t.Errorf("policy.json: %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// supported policy value types
+const (
+	TypeInt     = "int"
+	TypeDecimal = "decimal"
+	TypeString  = "string"
+	TypeBool    = "bool"
+	TypeDate    = "date"
+	// time of day, e.g. 12:00:00
+	TypeTime = "time"
+	// unix epoch or ISO-8601 date time
+	TypeTimestamp = "timestamp"
+)
+
+// Value describes where a policy value is located in the plaintext
+// and how the circuit must read it to compare at the declared precision
+type Value struct {
+	// plaintext byte offsets of the value, end is exclusive
+	Start int
+	End   int
+	// offsets relative to Start of non digit bytes the circuit skips,
+	// e.g. decimal points, thousands separators or date separators
+	Skip []int
+	// number of implicit trailing zero digits to reach the policy scale
+	Shift int
+	// integer representation at the policy precision,
+	// empty for string and bool values
+	Number string
+	// raw bytes of string and bool values
+	Text string
+}
+
+// Type returns the value type of the policy, integers by default
+func (p Policy) Type() string {
+	if p.ValueType == "" {
+		return TypeInt
+	}
+	return p.ValueType
+}
+
+// Numeric reports whether values of the policy are compared as integers
+func (p Policy) Numeric() bool {
+	switch p.Type() {
+	case TypeInt, TypeDecimal, TypeDate, TypeTime, TypeTimestamp:
+		return true
+	}
+	return false
+}
+
+// LocateValue finds the boundaries of the value starting at index start of
+// the plaintext and normalizes it according to the policy value type
+func (p Policy) LocateValue(plaintext string, start int) (Value, error) {
+
+	if start < 0 || start >= len(plaintext) {
+		return Value{}, errors.New("value start index out of plaintext range")
+	}
+
+	// quoted values end at the closing quote
+	quoted := start > 0 && plaintext[start-1] == '"'
+
+	var end int
+	switch p.Type() {
+	case TypeString:
+		if !quoted {
+			return Value{}, errors.New("string value must be quoted")
+		}
+		end = strings.IndexByte(plaintext[start:], '"')
+		if end < 0 {
+			return Value{}, errors.New("could not find closing quote of value")
+		}
+		end += start
+	case TypeBool:
+		switch {
+		case strings.HasPrefix(plaintext[start:], "true"):
+			end = start + len("true")
+		case strings.HasPrefix(plaintext[start:], "false"):
+			end = start + len("false")
+		default:
+			return Value{}, errors.New("could not parse bool value")
+		}
+	default:
+		// numeric values end at the first byte which is neither a digit nor a separator,
+		// e.g. the closing quote or a unit suffix such as " Euro"
+		end = start
+		for end < len(plaintext) && p.numericByte(plaintext[end]) {
+			end++
+		}
+	}
+
+	if p.ValueLength > 0 && end-start > p.ValueLength {
+		return Value{}, fmt.Errorf("value length %d exceeds policy value_length %d", end-start, p.ValueLength)
+	}
+
+	value := Value{Start: start, End: end}
+	if !p.Numeric() {
+		value.Text = plaintext[start:end]
+		return value, nil
+	}
+
+	number, skip, shift, err := p.normalize(plaintext[start:end])
+	if err != nil {
+		return Value{}, err
+	}
+	value.Number = number
+	value.Skip = skip
+	value.Shift = shift
+	return value, nil
+}
+
+// NormalizeThreshold returns the policy threshold as integer at the policy precision
+func (p Policy) NormalizeThreshold() (string, error) {
+	raw := p.ThresholdValue
+	// freshness threshold relative to now
+	if p.MaxAge > 0 {
+		raw = p.freshnessThreshold(time.Now())
+	}
+	number, err := p.NormalizeNumber(raw)
+	if err != nil {
+		return "", fmt.Errorf("threshold_value: %w", err)
+	}
+	return number, nil
+}
+
+// NormalizeBounds returns the inclusive BETWEEN bounds as integers at the policy precision
+func (p Policy) NormalizeBounds() (string, string, error) {
+	lower, err := p.NormalizeNumber(p.LowerValue)
+	if err != nil {
+		return "", "", fmt.Errorf("lower_value: %w", err)
+	}
+	upper, err := p.NormalizeNumber(p.UpperValue)
+	if err != nil {
+		return "", "", fmt.Errorf("upper_value: %w", err)
+	}
+	return lower, upper, nil
+}
+
+// NormalizeValues returns the IN and NOT_IN set members as integers at the policy precision
+func (p Policy) NormalizeValues() ([]string, error) {
+	numbers := make([]string, len(p.Values))
+	for i, raw := range p.Values {
+		number, err := p.NormalizeNumber(raw)
+		if err != nil {
+			return nil, fmt.Errorf("values[%d]: %w", i, err)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
+
+// NormalizeNumber returns a numeric policy value as integer at the policy precision
+func (p Policy) NormalizeNumber(raw string) (string, error) {
+	if !p.Numeric() {
+		return "", fmt.Errorf("value_type %q has no numeric representation", p.Type())
+	}
+	number, _, shift, err := p.normalize(raw)
+	if err != nil {
+		return "", err
+	}
+	return number + strings.Repeat("0", shift), nil
+}
+
+// Holds evaluates the policy value constraint on a located value
+func (p Policy) Holds(v Value) (bool, error) {
+
+	if !p.Numeric() {
+		return p.holdsText(v)
+	}
+
+	a, ok := new(big.Int).SetString(v.Number+strings.Repeat("0", v.Shift), 10)
+	if !ok {
+		return false, fmt.Errorf("invalid value number %q", v.Number)
+	}
+
+	switch p.ValueConstraint {
+	case "BETWEEN":
+		lower, upper, err := p.NormalizeBounds()
+		if err != nil {
+			return false, err
+		}
+		return a.Cmp(bigInt(lower)) >= 0 && a.Cmp(bigInt(upper)) <= 0, nil
+	case "IN", "NOT_IN":
+		members, err := p.NormalizeValues()
+		if err != nil {
+			return false, err
+		}
+		found := false
+		for _, member := range members {
+			if a.Cmp(bigInt(member)) == 0 {
+				found = true
+			}
+		}
+		return found == (p.ValueConstraint == "IN"), nil
+	}
+
+	threshold, err := p.NormalizeThreshold()
+	if err != nil {
+		return false, err
+	}
+
+	cmp := a.Cmp(bigInt(threshold))
+	switch p.ValueConstraint {
+	case "GT":
+		return cmp > 0, nil
+	case "LT":
+		return cmp < 0, nil
+	case "EQ":
+		return cmp == 0, nil
+	case "GE":
+		return cmp >= 0, nil
+	case "LE":
+		return cmp <= 0, nil
+	case "NE":
+		return cmp != 0, nil
+	}
+	return false, fmt.Errorf("unknown value_constraint %q", p.ValueConstraint)
+}
+
+// bigInt parses a normalized number
+func bigInt(number string) *big.Int {
+	n, _ := new(big.Int).SetString(number, 10)
+	return n
+}
+
+// numericByte reports whether b may be part of a numeric value of the policy type
+func (p Policy) numericByte(b byte) bool {
+	if b >= '0' && b <= '9' {
+		return true
+	}
+	switch p.Type() {
+	case TypeDecimal:
+		return b == '.' || (p.ThousandsSeparator != "" && b == p.ThousandsSeparator[0])
+	case TypeInt:
+		return p.ThousandsSeparator != "" && b == p.ThousandsSeparator[0]
+	}
+	return p.dateSeparator(b)
+}
+
+// Separators returns the non digit bytes a numeric value of the policy may contain
+func (p Policy) Separators() []byte {
+	separators := []byte{}
+	for b := 0; b < 256; b++ {
+		if (b < '0' || b > '9') && p.numericByte(byte(b)) {
+			separators = append(separators, byte(b))
+		}
+	}
+	return separators
+}
+
+// normalize strips separators of a numeric value and returns its digits,
+// the skipped byte offsets and the number of digits missing to reach the policy scale
+func (p Policy) normalize(raw string) (string, []int, int, error) {
+
+	if raw == "" {
+		return "", nil, 0, errors.New("empty numeric value")
+	}
+
+	// dates and times compare as digits of their format
+	if p.dateType() {
+		digits, skip, err := p.normalizeDate(raw)
+		return digits, skip, 0, err
+	}
+
+	var digits strings.Builder
+	skip := []int{}
+	fraction := -1
+	for i := 0; i < len(raw); i++ {
+		b := raw[i]
+		switch {
+		case b >= '0' && b <= '9':
+			digits.WriteByte(b)
+			if fraction >= 0 {
+				fraction++
+			}
+		case p.Type() == TypeDecimal && b == '.':
+			if fraction >= 0 {
+				return "", nil, 0, fmt.Errorf("value %q has more than one decimal point", raw)
+			}
+			fraction = 0
+			skip = append(skip, i)
+		case p.ThousandsSeparator != "" && b == p.ThousandsSeparator[0]:
+			if fraction >= 0 {
+				return "", nil, 0, fmt.Errorf("value %q has thousands separator after decimal point", raw)
+			}
+			skip = append(skip, i)
+		default:
+			return "", nil, 0, fmt.Errorf("unexpected byte %q in value %q", b, raw)
+		}
+	}
+
+	if digits.Len() == 0 {
+		return "", nil, 0, fmt.Errorf("value %q contains no digits", raw)
+	}
+
+	switch p.Type() {
+	case TypeDecimal:
+		if fraction < 0 {
+			fraction = 0
+		}
+		if fraction > p.Scale {
+			return "", nil, 0, fmt.Errorf("value %q has more than %d fractional digits", raw, p.Scale)
+		}
+		return digits.String(), skip, p.Scale - fraction, nil
+	}
+	return digits.String(), skip, 0, nil
+}
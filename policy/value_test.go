@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocateValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		plaintext string
+		start     int
+		// located raw value, normalized number and skipped offsets
+		raw    string
+		number string
+		skip   []int
+		shift  int
+		err    string
+	}{
+		{"int", Policy{}, `"a":38002,`, 4, "38002", "38002", []int{}, 0, ""},
+		{"int with separator", Policy{ThousandsSeparator: ","}, `"a":"1,234,567"`, 5, "1,234,567", "1234567", []int{1, 5}, 0, ""},
+		{"decimal", Policy{ValueType: TypeDecimal, Scale: 2}, `"a":"12.5"`, 5, "12.5", "125", []int{2}, 1, ""},
+		{"decimal without fraction", Policy{ValueType: TypeDecimal, Scale: 2}, `"a":12}`, 4, "12", "12", []int{}, 2, ""},
+		{"decimal with separators", Policy{ValueType: TypeDecimal, Scale: 1, ThousandsSeparator: ","}, `"a":"30,001.5"`, 5, "30,001.5", "300015", []int{2, 6}, 0, ""},
+		{"decimal with unit", Policy{ValueType: TypeDecimal, Scale: 1}, `"a":"3.5 Euro"`, 5, "3.5", "35", []int{1}, 0, ""},
+		{"date", Policy{ValueType: TypeDate}, `"d":"2022-04-27"`, 5, "2022-04-27", "20220427", []int{4, 7}, 0, ""},
+		{"dotted date", Policy{ValueType: TypeDate, DateFormat: "YYYY.MM.DD"}, `"d":"2022.04.27"`, 5, "2022.04.27", "20220427", []int{4, 7}, 0, ""},
+		{"compact date", Policy{ValueType: TypeDate, DateFormat: "YYYYMMDD"}, `"d":20220427,`, 4, "20220427", "20220427", []int{}, 0, ""},
+		{"time", Policy{ValueType: TypeTime}, `"t":"12:00:59"`, 5, "12:00:59", "120059", []int{2, 5}, 0, ""},
+		{"unix timestamp", Policy{ValueType: TypeTimestamp}, `"ts":1651017600}`, 5, "1651017600", "1651017600", []int{}, 0, ""},
+		{"iso timestamp", Policy{ValueType: TypeTimestamp, DateFormat: "YYYY-MM-DDThh:mm:ssZ"}, `"ts":"2022-04-27T12:00:00Z"`, 6,
+			"2022-04-27T12:00:00Z", "20220427120000", []int{4, 7, 10, 13, 16, 19}, 0, ""},
+		{"string", Policy{ValueType: TypeString}, `"c":"DE",`, 5, "DE", "", nil, 0, ""},
+		{"bool", Policy{ValueType: TypeBool}, `"b":false}`, 4, "false", "", nil, 0, ""},
+
+		{"start out of range", Policy{}, `"a":1`, 5, "", "", nil, 0, "out of plaintext range"},
+		{"value too long", Policy{ValueLength: 3}, `"a":38002,`, 4, "", "", nil, 0, "exceeds policy value_length"},
+		{"no digits", Policy{}, `"a":null`, 4, "", "", nil, 0, "empty numeric value"},
+		{"separator only", Policy{ThousandsSeparator: ","}, `"a":",",`, 5, "", "", nil, 0, "contains no digits"},
+		{"two decimal points", Policy{ValueType: TypeDecimal, Scale: 2}, `"a":"1.2.3"`, 5, "", "", nil, 0, "more than one decimal point"},
+		{"separator in fraction", Policy{ValueType: TypeDecimal, Scale: 4, ThousandsSeparator: ","}, `"a":"1.2,3"`, 5, "", "", nil, 0, "thousands separator after decimal point"},
+		{"fraction exceeds scale", Policy{ValueType: TypeDecimal, Scale: 1}, `"a":"1.25"`, 5, "", "", nil, 0, "more than 1 fractional digits"},
+		{"invalid date", Policy{ValueType: TypeDate}, `"d":"2022-13-01"`, 5, "", "", nil, 0, "does not match date_format"},
+		{"short date", Policy{ValueType: TypeDate}, `"d":"2022-4-27"`, 5, "", "", nil, 0, "does not match date_format"},
+		{"invalid time", Policy{ValueType: TypeTime}, `"t":"25:00:00"`, 5, "", "", nil, 0, "does not match date_format"},
+		{"unquoted string", Policy{ValueType: TypeString}, `"c":DE,`, 4, "", "", nil, 0, "must be quoted"},
+		{"unterminated string", Policy{ValueType: TypeString}, `"c":"DE`, 5, "", "", nil, 0, "closing quote"},
+		{"invalid bool", Policy{ValueType: TypeBool}, `"b":yes}`, 4, "", "", nil, 0, "could not parse bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := tt.policy.LocateValue(tt.plaintext, tt.start)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if raw := tt.plaintext[value.Start:value.End]; raw != tt.raw {
+				t.Errorf("raw value %q, want %q", raw, tt.raw)
+			}
+			if value.Number != tt.number {
+				t.Errorf("number %q, want %q", value.Number, tt.number)
+			}
+			if !reflect.DeepEqual(value.Skip, tt.skip) {
+				t.Errorf("skip %v, want %v", value.Skip, tt.skip)
+			}
+			if value.Shift != tt.shift {
+				t.Errorf("shift %d, want %d", value.Shift, tt.shift)
+			}
+		})
+	}
+}
+
+func TestHolds(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		raw    string
+		holds  bool
+		err    string
+	}{
+		{"gt", Policy{ValueConstraint: "GT", ThresholdValue: "30001"}, "30002", true, ""},
+		{"gt equal", Policy{ValueConstraint: "GT", ThresholdValue: "30001"}, "30001", false, ""},
+		{"le zero", Policy{ValueConstraint: "LE", ThresholdValue: "0"}, "0", true, ""},
+		{"decimal at scale", Policy{ValueType: TypeDecimal, Scale: 2, ValueConstraint: "EQ", ThresholdValue: "12.50"}, "12.5", true, ""},
+		{"decimal threshold with separator", Policy{ValueType: TypeDecimal, Scale: 1, ThousandsSeparator: ",", ValueConstraint: "GE", ThresholdValue: "30,000.1"}, "30000.1", true, ""},
+		{"between bounds", Policy{ValueConstraint: "BETWEEN", LowerValue: "18", UpperValue: "25"}, "25", true, ""},
+		{"between outside", Policy{ValueConstraint: "BETWEEN", LowerValue: "18", UpperValue: "25"}, "26", false, ""},
+		{"in", Policy{ValueConstraint: "IN", Values: []string{"10", "20"}}, "20", true, ""},
+		{"not in", Policy{ValueConstraint: "NOT_IN", Values: []string{"10", "20"}}, "20", false, ""},
+		{"date", Policy{ValueType: TypeDate, ValueConstraint: "LT", ThresholdValue: "2022-04-28"}, "2022-04-27", true, ""},
+		{"invalid threshold", Policy{ValueConstraint: "GT", ThresholdValue: "1.5"}, "2", false, "threshold_value"},
+		{"unknown operator", Policy{ValueConstraint: "LIKE", ThresholdValue: "1"}, "2", false, "unknown value_constraint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := `"v":"` + tt.raw + `"`
+			value, err := tt.policy.LocateValue(plaintext, 5)
+			if err != nil {
+				t.Fatalf("LocateValue: %v", err)
+			}
+			holds, err := tt.policy.Holds(value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if holds != tt.holds {
+				t.Errorf("holds %v, want %v", holds, tt.holds)
+			}
+		})
+	}
+}
+
+func TestFreshnessThreshold(t *testing.T) {
+	now := time.Date(2022, 4, 27, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		policy    Policy
+		threshold string
+	}{
+		{"unix", Policy{ValueType: TypeTimestamp, MaxAge: 3600}, "1651057200"},
+		{"iso", Policy{ValueType: TypeTimestamp, DateFormat: "YYYY-MM-DDThh:mm:ssZ", MaxAge: 86400}, "2022-04-26T12:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if threshold := tt.policy.freshnessThreshold(now); threshold != tt.threshold {
+				t.Errorf("threshold %s, want %s", threshold, tt.threshold)
+			}
+		})
+	}
+}
+
+func TestCheckFreshness(t *testing.T) {
+	now := time.Date(2022, 4, 27, 12, 0, 0, 0, time.UTC)
+	unix := Policy{ValueType: TypeTimestamp, ValueConstraint: "GE", MaxAge: 3600}
+	iso := Policy{ValueType: TypeTimestamp, DateFormat: "YYYY-MM-DDThh:mm:ssZ", ValueConstraint: "GE", MaxAge: 86400}
+	tests := []struct {
+		name      string
+		policy    Policy
+		threshold string
+		err       string
+	}{
+		{"unix now", unix, "1651057200", ""},
+		{"unix within tolerance", unix, "1651057400", ""},
+		{"unix stale clock", unix, "1651050000", "outside"},
+		{"unix future clock", unix, "1651067200", "outside"},
+		{"iso now", iso, "20220426120000", ""},
+		{"iso stale clock", iso, "20220425120000", "outside"},
+		{"invalid", unix, "x", "invalid"},
+		{"no max_age", Policy{ValueType: TypeTimestamp}, "1651057200", "max_age"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckFreshness(tt.threshold, now, FreshnessTolerance)
+			if tt.err == "" && err != nil {
+				t.Fatalf("CheckFreshness: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package postprocess
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// httpResponse holds the stream offsets of a single http response
+// of the response stream, end is exclusive
+type httpResponse struct {
+	Start     int
+	BodyStart int
+	End       int
+	// lower cased status line and header fields
+	header string
+}
+
+// splitResponses splits the response stream into the consecutive http
+// responses of the exchanges of the session, bodies are framed by
+// chunked transfer encoding, content length or the end of the stream
+func splitResponses(stream string) ([]httpResponse, error) {
+
+	var responses []httpResponse
+	for i := 0; i < len(stream); {
+
+		// header ends with an empty line
+		headerEnd := strings.Index(stream[i:], "\r\n\r\n")
+		if headerEnd < 0 {
+			return nil, fmt.Errorf("incomplete http header at response offset %d", i)
+		}
+		response := httpResponse{
+			Start:     i,
+			BodyStart: i + headerEnd + 4,
+			header:    strings.ToLower(stream[i : i+headerEnd]),
+		}
+
+		status := strings.Fields(strings.SplitN(response.header, "\r\n", 2)[0])
+		contentLength := headerValue(response.header, "content-length")
+		switch {
+		case len(status) > 1 && (strings.HasPrefix(status[1], "1") || status[1] == "204" || status[1] == "304"):
+			// responses without body
+			response.End = response.BodyStart
+		case strings.Contains(headerValue(response.header, "transfer-encoding"), "chunked"):
+			_, _, end, err := decodeChunked(stream, response.BodyStart)
+			if err != nil {
+				return nil, err
+			}
+			response.End = end
+		case contentLength != "":
+			n, err := strconv.Atoi(contentLength)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid content length %q at response offset %d", contentLength, i)
+			}
+			response.End = response.BodyStart + n
+			if response.End > len(stream) {
+				response.End = len(stream)
+			}
+		default:
+			// body ends with the connection
+			response.End = len(stream)
+		}
+
+		// interim responses precede the response of the same exchange
+		if len(status) > 1 && strings.HasPrefix(status[1], "1") {
+			i = response.End
+			continue
+		}
+		responses = append(responses, response)
+		i = response.End
+	}
+
+	if len(responses) == 0 {
+		return nil, errors.New("no http response in server records")
+	}
+	return responses, nil
+}
+
+// decodedBody is the logical http body of a response after removing the
+// transfer encoding, together with the stream offset of each body byte
+type decodedBody struct {
+	Text    string
+	Offsets []int
+}
+
+// decodeBody decodes the http body of a response of the response stream,
+// chunked bodies are reassembled and compressed bodies are rejected
+// because their bytes cannot be mapped to ciphertext bytes
+func decodeBody(stream string, response httpResponse) (decodedBody, error) {
+
+	// content encoding
+	encoding := headerValue(response.header, "content-encoding")
+	if encoding != "" && encoding != "identity" {
+		return decodedBody{}, fmt.Errorf("content encoding %s cannot be mapped to record bytes, request the resource with Accept-Encoding: identity", encoding)
+	}
+
+	if !strings.Contains(headerValue(response.header, "transfer-encoding"), "chunked") {
+		offsets := make([]int, response.End-response.BodyStart)
+		for i := range offsets {
+			offsets[i] = response.BodyStart + i
+		}
+		return decodedBody{Text: stream[response.BodyStart:response.End], Offsets: offsets}, nil
+	}
+
+	text, offsets, _, err := decodeChunked(stream, response.BodyStart)
+	if err != nil {
+		return decodedBody{}, err
+	}
+	return decodedBody{Text: text, Offsets: offsets}, nil
+}
+
+// decodeChunked reassembles a chunked body starting at offset start and returns
+// the body, the stream offset of each body byte and the end of the chunked body
+func decodeChunked(stream string, start int) (string, []int, int, error) {
+
+	// chunk size line, chunk data and crlf
+	var text strings.Builder
+	var offsets []int
+	i := start
+	for i < len(stream) {
+		lineEnd := strings.Index(stream[i:], "\r\n")
+		if lineEnd < 0 {
+			// truncated response
+			break
+		}
+		sizeField := strings.TrimSpace(strings.SplitN(stream[i:i+lineEnd], ";", 2)[0])
+		size, err := strconv.ParseInt(sizeField, 16, 64)
+		if err != nil || size < 0 {
+			return "", nil, 0, fmt.Errorf("invalid chunk size %q at response offset %d", sizeField, i)
+		}
+		i += lineEnd + 2
+		if size == 0 {
+			// optional trailer fields end with an empty line
+			trailerEnd := strings.Index(stream[i:], "\r\n")
+			for trailerEnd > 0 {
+				i += trailerEnd + 2
+				trailerEnd = strings.Index(stream[i:], "\r\n")
+			}
+			if trailerEnd == 0 {
+				i += 2
+			}
+			break
+		}
+		for j := 0; j < int(size) && i < len(stream); j++ {
+			text.WriteByte(stream[i])
+			offsets = append(offsets, i)
+			i++
+		}
+		i += 2
+	}
+	if i > len(stream) {
+		i = len(stream)
+	}
+	return text.String(), offsets, i, nil
+}
+
+// maximum number of hexadecimal digits of a chunk size the circuit removes
+const maxChunkSizeDigits = 8
+
+// streamRange returns the stream range of the decoded body range [start, end)
+// and the stream ranges of the chunk framing inside it, which the circuit removes
+// from the plaintext. Framing is a crlf, the chunk size and a crlf.
+func (b decodedBody) streamRange(stream string, start int, end int) (int, int, [][2]int, error) {
+	if start < 0 || end > len(b.Offsets) || start >= end {
+		return 0, 0, nil, errors.New("area of interest exceeds the http body of the response")
+	}
+	var framing [][2]int
+	for i := start + 1; i < end; i++ {
+		if b.Offsets[i] == b.Offsets[i-1]+1 {
+			continue
+		}
+		frame := [2]int{b.Offsets[i-1] + 1, b.Offsets[i]}
+		line := stream[frame[0]:frame[1]]
+		size := strings.TrimSuffix(strings.TrimPrefix(line, "\r\n"), "\r\n")
+		if len(size) != len(line)-4 || len(size) == 0 || len(size) > maxChunkSizeDigits || strings.Trim(size, "0123456789abcdefABCDEF") != "" {
+			return 0, 0, nil, fmt.Errorf("chunk size line %q at response offset %d cannot be removed by the circuit, chunk extensions and whitespace are not supported", size, frame[0])
+		}
+		framing = append(framing, frame)
+	}
+	return b.Offsets[start], b.Offsets[end-1] + 1, framing, nil
+}
+
+// headerValue returns the trimmed value of the first header field
+// with the given lower case name in a lower cased http header
+func headerValue(header string, name string) string {
+	for _, line := range strings.Split(header, "\r\n") {
+		field := strings.SplitN(line, ":", 2)
+		if len(field) == 2 && strings.TrimSpace(field[0]) == name {
+			return strings.TrimSpace(field[1])
+		}
+	}
+	return ""
+}
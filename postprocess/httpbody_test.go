@@ -0,0 +1,198 @@
+package postprocess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeChunked(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		text   string
+		// stream offsets of the body bytes and end of the chunked body
+		offsets []int
+		end     int
+		err     string
+	}{
+		{"single chunk", "3\r\nabc\r\n0\r\n\r\n", "abc", []int{3, 4, 5}, 13, ""},
+		{"two chunks", "2\r\nab\r\n1\r\nc\r\n0\r\n\r\n", "abc", []int{3, 4, 10}, 18, ""},
+		{"extension", "2;name=value\r\nab\r\n0\r\n\r\n", "ab", []int{14, 15}, 23, ""},
+		{"hex size", "a\r\n0123456789\r\n0\r\n\r\n", "0123456789", []int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, 20, ""},
+		{"trailer", "1\r\na\r\n0\r\nexpires: never\r\n\r\n", "a", []int{3}, 27, ""},
+		{"next response follows", "1\r\na\r\n0\r\n\r\nHTTP/1.1", "a", []int{3}, 11, ""},
+		{"truncated chunk", "5\r\nab", "ab", []int{3, 4}, 5, ""},
+		{"truncated size line", "1\r\na\r\n5", "a", []int{3}, 6, ""},
+
+		{"invalid size", "x\r\nabc\r\n0\r\n\r\n", "", nil, 0, "invalid chunk size"},
+		{"negative size", "-1\r\na\r\n0\r\n\r\n", "", nil, 0, "invalid chunk size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, offsets, end, err := decodeChunked(tt.stream, 0)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("text %q, want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(offsets, tt.offsets) {
+				t.Errorf("offsets %v, want %v", offsets, tt.offsets)
+			}
+			if end != tt.end {
+				t.Errorf("end %d, want %d", end, tt.end)
+			}
+		})
+	}
+}
+
+func TestSplitResponses(t *testing.T) {
+	chunked := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nab\r\n0\r\n\r\n"
+	continued := "HTTP/1.1 100 Continue\r\n\r\n"
+	noContent := "HTTP/1.1 204 No Content\r\n\r\n"
+	unframed := "HTTP/1.0 200 OK\r\n\r\nuntil close"
+
+	tests := []struct {
+		name   string
+		stream string
+		// start and end offset of each response
+		bounds [][2]int
+		err    string
+	}{
+		{"content length", testResponse("ab"), [][2]int{{0, len(testResponse("ab"))}}, ""},
+		{"two exchanges", testResponse("ab") + testResponse("cd"),
+			[][2]int{{0, len(testResponse("ab"))}, {len(testResponse("ab")), 2 * len(testResponse("ab"))}}, ""},
+		{"chunked then content length", chunked + testResponse("cd"),
+			[][2]int{{0, len(chunked)}, {len(chunked), len(chunked) + len(testResponse("cd"))}}, ""},
+		{"interim response skipped", continued + testResponse("ab"), [][2]int{{len(continued), len(continued) + len(testResponse("ab"))}}, ""},
+		{"no content", noContent + testResponse("ab"), [][2]int{{0, len(noContent)}, {len(noContent), len(noContent) + len(testResponse("ab"))}}, ""},
+		{"body until end of stream", unframed, [][2]int{{0, len(unframed)}}, ""},
+		{"truncated body", testResponse("abcd")[:len(testResponse("abcd"))-2], [][2]int{{0, len(testResponse("abcd")) - 2}}, ""},
+
+		{"empty stream", "", nil, "no http response"},
+		{"incomplete header", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n", nil, "incomplete http header"},
+		{"invalid content length", "HTTP/1.1 200 OK\r\nContent-Length: -2\r\n\r\nab", nil, "invalid content length"},
+		{"invalid chunk size", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nab\r\n0\r\n\r\n", nil, "invalid chunk size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses, err := splitResponses(tt.stream)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var bounds [][2]int
+			for _, response := range responses {
+				bounds = append(bounds, [2]int{response.Start, response.End})
+			}
+			if !reflect.DeepEqual(bounds, tt.bounds) {
+				t.Errorf("responses %v, want %v", bounds, tt.bounds)
+			}
+		})
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		text   string
+		err    string
+	}{
+		{"content length", testResponse(`{"a":1}`), `{"a":1}`, ""},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\n{\"a\r\n4\r\n\":1}\r\n0\r\n\r\n", `{"a":1}`, ""},
+		{"identity encoding", "HTTP/1.1 200 OK\r\nContent-Encoding: identity\r\nContent-Length: 2\r\n\r\nab", "ab", ""},
+
+		{"gzip", "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: 2\r\n\r\nab", "", "content encoding gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses, err := splitResponses(tt.stream)
+			if err != nil {
+				t.Fatalf("splitResponses: %v", err)
+			}
+			body, err := decodeBody(tt.stream, responses[0])
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if body.Text != tt.text {
+				t.Errorf("body %q, want %q", body.Text, tt.text)
+			}
+			for i := range body.Text {
+				if tt.stream[body.Offsets[i]] != body.Text[i] {
+					t.Fatalf("body byte %d maps to stream byte %q", i, tt.stream[body.Offsets[i]])
+				}
+			}
+		})
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	// body "abcdef" in chunks "abc" and "def"
+	chunked := "3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"
+	extended := "3\r\nabc\r\n3;x=1\r\ndef\r\n0\r\n\r\n"
+
+	tests := []struct {
+		name       string
+		stream     string
+		start, end int
+		// stream range and framing inside it
+		streamStart, streamEnd int
+		framing                [][2]int
+		err                    string
+	}{
+		{"first chunk", chunked, 0, 3, 3, 6, nil, ""},
+		{"second chunk", chunked, 3, 6, 11, 14, nil, ""},
+		{"single byte", chunked, 5, 6, 13, 14, nil, ""},
+		{"straddles chunk boundary", chunked, 2, 4, 5, 12, [][2]int{{6, 11}}, ""},
+
+		{"chunk extension", extended, 2, 4, 0, 0, nil, "chunk extensions and whitespace are not supported"},
+		{"empty range", chunked, 2, 2, 0, 0, nil, "exceeds the http body"},
+		{"beyond body", chunked, 4, 7, 0, 0, nil, "exceeds the http body"},
+		{"negative start", chunked, -1, 2, 0, 0, nil, "exceeds the http body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, offsets, _, err := decodeChunked(tt.stream, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := decodedBody{Text: text, Offsets: offsets}
+			start, end, framing, err := body.streamRange(tt.stream, tt.start, tt.end)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if start != tt.streamStart || end != tt.streamEnd || !reflect.DeepEqual(framing, tt.framing) {
+				t.Errorf("range [%d,%d) with framing %v, want [%d,%d) with %v", start, end, framing, tt.streamStart, tt.streamEnd, tt.framing)
+			}
+		})
+	}
+}
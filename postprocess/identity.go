@@ -0,0 +1,254 @@
+package postprocess
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	u "client/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// record header length, content type of handshake records
+// and handshake message types of the server flight
+const (
+	recordHeaderLen           = 5
+	recordTypeHandshake       = 22
+	recordTypeApplicationData = 23
+	handshakeHeaderLen        = 4
+	typeCertificate           = 11
+	typeCertificateVerify     = 15
+	typeFinished              = 20
+)
+
+// handshakeMessage is a server handshake message and the
+// sequence numbers of the records holding its first and last byte
+type handshakeMessage struct {
+	typ   byte
+	body  []byte
+	first int
+	last  int
+}
+
+// ServerIdentity decrypts the recorded server handshake records with the server handshake
+// traffic secret, checks that the Certificate message carries the recorded peer chain
+// and names the server domain, and stores the encrypted Certificate and CertificateVerify
+// records, so that the proxy checks them against the transcript hashes H2, H3 and H7
+func ServerIdentity(toBshared map[string]string) error {
+
+	suite, err := suiteOf(toBshared)
+	if err != nil {
+		log.Error().Err(err).Msg("suiteOf(toBshared)")
+		return err
+	}
+
+	// server handshake traffic key and iv
+	SHTS, _ := hex.DecodeString(toBshared["SHTS"])
+	aead, iv, err := suite.handshakeAEAD(SHTS)
+	if err != nil {
+		log.Error().Err(err).Msg("suite.handshakeAEAD(SHTS)")
+		return err
+	}
+
+	// decrypt records up to the server finished
+	messages, records, err := serverHandshake(toBshared, aead, iv)
+	if err != nil {
+		log.Error().Err(err).Msg("serverHandshake()")
+		return err
+	}
+	var certificate, certificateVerify, finished *handshakeMessage
+	for i := range messages {
+		switch messages[i].typ {
+		case typeCertificate:
+			certificate = &messages[i]
+		case typeCertificateVerify:
+			certificateVerify = &messages[i]
+		case typeFinished:
+			finished = &messages[i]
+		}
+	}
+	if certificate == nil || certificateVerify == nil || finished == nil {
+		err = errors.New("server handshake lacks Certificate, CertificateVerify or Finished")
+		log.Error().Err(err).Msg("serverHandshake()")
+		return err
+	}
+	if sf := toBshared["recordHashSF"]; sf != "" && sf != fmt.Sprintf("%016x", finished.last) {
+		err = fmt.Errorf("server finished in handshake record %016x, recorded SF %s", finished.last, sf)
+		log.Error().Err(err).Msg("toBshared[\"recordHashSF\"]")
+		return err
+	}
+
+	// certificate chain of the transcript against the recorded peer chain
+	chain, err := certificateList(certificate.body)
+	if err != nil {
+		log.Error().Err(err).Msg("certificateList()")
+		return err
+	}
+	for i, cert := range chain {
+		if toBshared[fmt.Sprintf("certificates_%d", i)] != hex.EncodeToString(cert) {
+			err = fmt.Errorf("certificate %d of the handshake does not match the peer certificate", i)
+			log.Error().Err(err).Msg("certificateList()")
+			return err
+		}
+	}
+	if _, ok := toBshared[fmt.Sprintf("certificates_%d", len(chain))]; ok {
+		err = errors.New("recorded peer chain is longer than the handshake chain")
+		log.Error().Err(err).Msg("certificateList()")
+		return err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		log.Error().Err(err).Msg("x509.ParseCertificate(chain[0])")
+		return err
+	}
+	domain := toBshared["server_domain"]
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	if domain != "" {
+		err = leaf.VerifyHostname(domain)
+		if err != nil {
+			log.Error().Err(err).Msg("leaf.VerifyHostname(domain)")
+			return err
+		}
+	}
+	if len(certificateVerify.body) < 2 {
+		err = errors.New("CertificateVerify too short")
+		log.Error().Err(err).Msg("certificateVerify")
+		return err
+	}
+
+	// fill data structure
+	jsonData := make(map[string]string)
+	jsonData["server_domain"] = domain
+	jsonData["cipher_suite"] = suite.name
+	jsonData["H2"] = toBshared["H2"]
+	jsonData["H3"] = toBshared["H3"]
+	jsonData["H7"] = toBshared["H7"]
+	for i, cert := range chain {
+		jsonData[fmt.Sprintf("certificate_%d", i)] = hex.EncodeToString(cert)
+	}
+	jsonData["signature_scheme"] = hex.EncodeToString(certificateVerify.body[:2])
+	jsonData["certificate_sequence"] = fmt.Sprintf("%016x", certificate.first)
+	jsonData["certificate_verify_sequence"] = fmt.Sprintf("%016x", certificateVerify.last)
+	for seq := certificate.first; seq <= certificateVerify.last; seq++ {
+		jsonData[fmt.Sprintf("record_%016x", seq)] = hex.EncodeToString(records[seq])
+	}
+
+	// store data
+	err = u.StoreM(jsonData, "server_identity")
+	if err != nil {
+		log.Error().Msg("u.StoreM")
+		return err
+	}
+	return nil
+}
+
+// serverHandshake decrypts the recorded handshake records in order of their sequence
+// number and splits their content into handshake messages up to the server finished
+func serverHandshake(toBshared map[string]string, aead cipher.AEAD, iv []byte) ([]handshakeMessage, [][]byte, error) {
+
+	var messages []handshakeMessage
+	var records [][]byte
+	var stream []byte
+	// record sequence of each content byte
+	var sequences []int
+	for seq := 0; ; seq++ {
+		sequence := fmt.Sprintf("%016x", seq)
+		record, _ := hex.DecodeString(toBshared["handshake_"+sequence])
+		if len(record) <= recordHeaderLen {
+			return nil, nil, errors.New("handshake records end before the server finished")
+		}
+		records = append(records, record)
+
+		nonce, err := recordNonce(iv, sequence)
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := aead.Open(nil, nonce, record[recordHeaderLen:], record[:recordHeaderLen])
+		if err != nil {
+			return nil, nil, fmt.Errorf("handshake record %s: %w", sequence, err)
+		}
+		contentEnd, contentType := recordContent(payload)
+		if contentType != recordTypeHandshake {
+			return nil, nil, fmt.Errorf("handshake record %s carries no handshake message", sequence)
+		}
+		stream = append(stream, payload[:contentEnd]...)
+		for range payload[:contentEnd] {
+			sequences = append(sequences, seq)
+		}
+
+		// complete messages
+		for len(stream) >= handshakeHeaderLen {
+			length := handshakeHeaderLen + int(uint32(stream[1])<<16|uint32(stream[2])<<8|uint32(stream[3]))
+			if len(stream) < length {
+				break
+			}
+			message := handshakeMessage{
+				typ:   stream[0],
+				body:  stream[handshakeHeaderLen:length],
+				first: sequences[0],
+				last:  sequences[length-1],
+			}
+			messages = append(messages, message)
+			stream = stream[length:]
+			sequences = sequences[length:]
+			if message.typ == typeFinished {
+				return messages, records, nil
+			}
+		}
+	}
+}
+
+// certificateList returns the der encoded certificates of a tls 1.3 Certificate message
+func certificateList(body []byte) ([][]byte, error) {
+	reader := bytes.NewReader(body)
+	contextLen, err := reader.ReadByte()
+	if err != nil || int(contextLen) > reader.Len() {
+		return nil, errors.New("malformed Certificate message")
+	}
+	reader.Seek(int64(contextLen), io.SeekCurrent)
+	listLen, err := readUint24(reader)
+	if err != nil || listLen != reader.Len() {
+		return nil, errors.New("malformed Certificate message")
+	}
+
+	// certificate entries with extensions
+	var chain [][]byte
+	for reader.Len() > 0 {
+		certLen, err := readUint24(reader)
+		if err != nil || certLen == 0 || certLen > reader.Len() {
+			return nil, errors.New("malformed Certificate entry")
+		}
+		cert := make([]byte, certLen)
+		io.ReadFull(reader, cert)
+		var extLen uint16
+		err = binary.Read(reader, binary.BigEndian, &extLen)
+		if err != nil || int(extLen) > reader.Len() {
+			return nil, errors.New("malformed Certificate entry extensions")
+		}
+		reader.Seek(int64(extLen), io.SeekCurrent)
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("empty server certificate chain")
+	}
+	return chain, nil
+}
+
+// readUint24 reads a big endian 24 bit length
+func readUint24(reader *bytes.Reader) (int, error) {
+	var b [3]byte
+	_, err := io.ReadFull(reader, b[:])
+	if err != nil {
+		return 0, err
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2]), nil
+}
@@ -0,0 +1,222 @@
+package postprocess
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	p "client/policy"
+)
+
+// jsonMatch holds body byte offsets of a resolved selector
+type jsonMatch struct {
+	// quoted object key, end is exclusive
+	KeyStart int
+	KeyEnd   int
+	// raw json value, strings include their quotes
+	ValueStart int
+	ValueEnd   int
+}
+
+// jsonScanner walks a json document and keeps track of byte offsets
+type jsonScanner struct {
+	s string
+	i int
+}
+
+// resolveSelector resolves the policy selector against a json body
+// and returns the byte offsets of the selected key and value
+func resolveSelector(body string, policy p.Policy) (jsonMatch, error) {
+
+	segments, err := policy.Segments()
+	if err != nil {
+		return jsonMatch{}, err
+	}
+
+	sc := &jsonScanner{s: body}
+	sc.skipSpace()
+
+	var match jsonMatch
+	for _, segment := range segments {
+		if segment.IsIndex {
+			match.ValueStart, match.ValueEnd, err = sc.element(segment.Index)
+		} else {
+			match, err = sc.member(segment.Key)
+		}
+		if err != nil {
+			return jsonMatch{}, fmt.Errorf("selector %q: %w", policy.Selector, err)
+		}
+		// continue resolving inside the selected value
+		sc.i = match.ValueStart
+	}
+
+	return match, nil
+}
+
+// member finds key in the object at the current position
+// and fails if the key is missing or occurs more than once
+func (sc *jsonScanner) member(key string) (jsonMatch, error) {
+
+	if !sc.consume('{') {
+		return jsonMatch{}, fmt.Errorf("expected object at byte %d", sc.i)
+	}
+
+	var match jsonMatch
+	found := false
+	sc.skipSpace()
+	if sc.consume('}') {
+		return jsonMatch{}, fmt.Errorf("key %q not found", key)
+	}
+	for {
+		sc.skipSpace()
+		keyStart := sc.i
+		name, err := sc.str()
+		if err != nil {
+			return jsonMatch{}, err
+		}
+		keyEnd := sc.i
+		sc.skipSpace()
+		if !sc.consume(':') {
+			return jsonMatch{}, fmt.Errorf("expected ':' at byte %d", sc.i)
+		}
+		sc.skipSpace()
+		valueStart := sc.i
+		err = sc.skipValue()
+		if err != nil {
+			return jsonMatch{}, err
+		}
+		if name == key {
+			if found {
+				return jsonMatch{}, fmt.Errorf("key %q occurs more than once", key)
+			}
+			found = true
+			match = jsonMatch{KeyStart: keyStart, KeyEnd: keyEnd, ValueStart: valueStart, ValueEnd: sc.i}
+		}
+		sc.skipSpace()
+		if sc.consume(',') {
+			continue
+		}
+		if sc.consume('}') {
+			break
+		}
+		return jsonMatch{}, fmt.Errorf("expected ',' or '}' at byte %d", sc.i)
+	}
+
+	if !found {
+		return jsonMatch{}, fmt.Errorf("key %q not found", key)
+	}
+	return match, nil
+}
+
+// element finds the array element with index idx at the current position
+func (sc *jsonScanner) element(idx int) (int, int, error) {
+
+	if !sc.consume('[') {
+		return 0, 0, fmt.Errorf("expected array at byte %d", sc.i)
+	}
+	for n := 0; ; n++ {
+		sc.skipSpace()
+		if sc.consume(']') {
+			return 0, 0, fmt.Errorf("array index %d out of range", idx)
+		}
+		start := sc.i
+		err := sc.skipValue()
+		if err != nil {
+			return 0, 0, err
+		}
+		if n == idx {
+			return start, sc.i, nil
+		}
+		sc.skipSpace()
+		if !sc.consume(',') && !(sc.i < len(sc.s) && sc.s[sc.i] == ']') {
+			return 0, 0, fmt.Errorf("expected ',' or ']' at byte %d", sc.i)
+		}
+	}
+}
+
+// skipValue advances behind the json value at the current position
+func (sc *jsonScanner) skipValue() error {
+
+	if sc.i >= len(sc.s) {
+		return errors.New("unexpected end of json body")
+	}
+
+	switch sc.s[sc.i] {
+	case '"':
+		_, err := sc.str()
+		return err
+	case '{', '[':
+		// nested values are skipped by depth, strings may contain brackets
+		depth := 0
+		for sc.i < len(sc.s) {
+			switch sc.s[sc.i] {
+			case '"':
+				_, err := sc.str()
+				if err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			sc.i++
+			if depth == 0 {
+				return nil
+			}
+		}
+		return errors.New("unexpected end of json body")
+	default:
+		// numbers, true, false, null
+		start := sc.i
+		for sc.i < len(sc.s) && !strings.ContainsRune(",}] \t\r\n", rune(sc.s[sc.i])) {
+			sc.i++
+		}
+		if !json.Valid([]byte(sc.s[start:sc.i])) {
+			return fmt.Errorf("invalid json value at byte %d", start)
+		}
+		return nil
+	}
+}
+
+// str reads the json string at the current position and returns it unquoted
+func (sc *jsonScanner) str() (string, error) {
+
+	start := sc.i
+	if !sc.consume('"') {
+		return "", fmt.Errorf("expected string at byte %d", sc.i)
+	}
+	for sc.i < len(sc.s) {
+		switch sc.s[sc.i] {
+		case '\\':
+			sc.i += 2
+			continue
+		case '"':
+			sc.i++
+			var unquoted string
+			err := json.Unmarshal([]byte(sc.s[start:sc.i]), &unquoted)
+			if err != nil {
+				return "", fmt.Errorf("invalid string at byte %d", start)
+			}
+			return unquoted, nil
+		}
+		sc.i++
+	}
+	return "", errors.New("unexpected end of json body")
+}
+
+func (sc *jsonScanner) skipSpace() {
+	for sc.i < len(sc.s) && strings.ContainsRune(" \t\r\n", rune(sc.s[sc.i])) {
+		sc.i++
+	}
+}
+
+func (sc *jsonScanner) consume(b byte) bool {
+	if sc.i < len(sc.s) && sc.s[sc.i] == b {
+		sc.i++
+		return true
+	}
+	return false
+}
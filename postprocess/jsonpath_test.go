@@ -0,0 +1,68 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+
+	p "client/policy"
+)
+
+func TestResolveSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		selector string
+		// expected key and raw value, empty key for errors
+		key   string
+		value string
+		err   string
+	}{
+		{"top level", `{"balance":38002}`, "$.balance", `"balance"`, "38002", ""},
+		{"whitespace", "{ \"a\" : 1 ,\n \"balance\" :\t\"12.5\" }", "$.balance", `"balance"`, `"12.5"`, ""},
+		{"nested", `{"account":{"balance":{"amount":7}}}`, "$.account.balance.amount", `"amount"`, "7", ""},
+		{"key with space", `{"personal data":{"income":100}}`, "$.personal data.income", `"income"`, "100", ""},
+		{"array element", `{"items":[{"price":1},{"price":2}]}`, "$.items[1].price", `"price"`, "2", ""},
+		{"nested arrays", `{"m":[[{"v":1}],[{"v":3}]]}`, "$.m[1][0].v", `"v"`, "3", ""},
+		{"key text in string value", `{"note":"\"balance\":1","balance":2}`, "$.balance", `"balance"`, "2", ""},
+		{"key in sibling object", `{"old":{"balance":1},"balance":2}`, "$.balance", `"balance"`, "2", ""},
+		{"duplicate key in other object", `{"a":{"v":1},"b":{"v":2,"w":3}}`, "$.b.v", `"v"`, "2", ""},
+		{"brackets in strings", `{"s":"{[","v":{"x":"]}"},"k":true}`, "$.k", `"k"`, "true", ""},
+		{"escaped key", `{"a\"b":1,"c":2}`, "$.c", `"c"`, "2", ""},
+
+		{"duplicate key", `{"balance":1,"balance":2}`, "$.balance", "", "", "occurs more than once"},
+		{"duplicate nested key", `{"a":{"v":1,"v":1}}`, "$.a.v", "", "", "occurs more than once"},
+		{"missing key", `{"a":1}`, "$.balance", "", "", "not found"},
+		{"empty object", `{}`, "$.balance", "", "", "not found"},
+		{"not an object", `[1,2]`, "$.balance", "", "", "expected object"},
+		{"not an array", `{"items":{"price":1}}`, "$.items[0].price", "", "", "expected array"},
+		{"index out of range", `{"items":[{"price":1}]}`, "$.items[1].price", "", "", "out of range"},
+		{"truncated body", `{"a":{"balance":1`, "$.a.balance", "", "", "unexpected end"},
+		{"missing separator", `{"a":1 "b":2}`, "$.b", "", "", "expected ',' or '}'"},
+		{"unterminated string", `{"a":"x`, "$.b", "", "", "unexpected end"},
+		{"invalid value", `{"a":tru,"balance":1}`, "$.balance", "", "", "invalid json value"},
+		{"missing colon", `{"a" 1}`, "$.a", "", "", "expected ':'"},
+		{"selector without root", `{"a":1}`, "a", "", "", "must start with"},
+		{"selector ending in index", `{"a":[1]}`, "$.a[0]", "", "", "must end with an object key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := resolveSelector(tt.body, p.Policy{Selector: tt.selector})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if key := tt.body[match.KeyStart:match.KeyEnd]; key != tt.key {
+				t.Errorf("key %q, want %q", key, tt.key)
+			}
+			if value := tt.body[match.ValueStart:match.ValueEnd]; value != tt.value {
+				t.Errorf("value %q, want %q", value, tt.value)
+			}
+		})
+	}
+}
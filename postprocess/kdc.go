@@ -1,8 +1,11 @@
 package postprocess
 
 import (
+	"crypto/hmac"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 
@@ -31,6 +34,9 @@ func KdcShared(toBshared, sdataMap, cdataMap map[string]string) error {
 	jsonData["hashKeySapp"] = sdataMap["hashKeySapp"]
 	jsonData["hashIvSapp"] = sdataMap["hashIvSapp"]
 	jsonData["SHTS"] = toBshared["SHTS"]
+	// with client authentication the transcript hashes cover the server
+	// CertificateRequest, client Certificate and CertificateVerify follow SF
+	jsonData["client_auth"] = toBshared["client_auth"]
 
 	// store data
 	err := u.StoreM(jsonData, "kdc_shared")
@@ -61,6 +67,8 @@ func KdcPublicInput(sdataMap, cdataMap map[string]string) error {
 	// required for the case where circuit is decoupled
 	jsonData["hashKeySapp"] = sdataMap["hashKeySapp"]
 	jsonData["hashKeyCapp"] = cdataMap["hashKeyCapp"]
+	// negotiated suite, the verifier rejects suites the circuit does not implement
+	jsonData["cipher_suite"] = sdataMap["cipher_suite"]
 
 	// store data
 	err := u.StoreM(jsonData, "kdc_public_input")
@@ -88,6 +96,13 @@ func KdcPrivateInput(sdataMap map[string]string) error {
 
 func DeriveKeyIvSATS(toBshared map[string]string) (map[string]string, error) {
 
+	// the zk key derivation implements the sha256 key schedule
+	suite, err := suiteOf(toBshared)
+	if err != nil {
+		log.Error().Err(err).Msg("suiteOf(toBshared)")
+		return nil, err
+	}
+
 	// derive sats values
 	HS, _ := hex.DecodeString(toBshared["HS"])
 	H3, _ := hex.DecodeString(toBshared["H3"])
@@ -138,9 +153,10 @@ func DeriveKeyIvSATS(toBshared map[string]string) (map[string]string, error) {
 	jsonData["ivSapp"] = hex.EncodeToString(iv)
 	jsonData["hashKeySapp"] = hex.EncodeToString(tls.Sum256(key))
 	jsonData["hashIvSapp"] = hex.EncodeToString(tls.Sum256(iv))
+	jsonData["cipher_suite"] = suite.name
 
 	// store data
-	err := u.StoreM(jsonData, "skdc_params")
+	err = u.StoreM(jsonData, "skdc_params")
 	if err != nil {
 		log.Error().Msg("u.StoreM")
 		return nil, err
@@ -150,6 +166,13 @@ func DeriveKeyIvSATS(toBshared map[string]string) (map[string]string, error) {
 
 func DeriveKeyIvCATS(toBshared map[string]string) (map[string]string, error) {
 
+	// the zk key derivation implements the sha256 key schedule
+	suite, err := suiteOf(toBshared)
+	if err != nil {
+		log.Error().Err(err).Msg("suiteOf(toBshared)")
+		return nil, err
+	}
+
 	// derive sats values
 	HS, _ := hex.DecodeString(toBshared["HS"])
 	H3, _ := hex.DecodeString(toBshared["H3"])
@@ -190,9 +213,10 @@ func DeriveKeyIvCATS(toBshared map[string]string) (map[string]string, error) {
 	jsonData["ivCapp"] = hex.EncodeToString(iv)
 	jsonData["hashKeyCapp"] = hex.EncodeToString(tls.Sum256(key))
 	jsonData["hashIvCapp"] = hex.EncodeToString(tls.Sum256(iv))
+	jsonData["cipher_suite"] = suite.name
 
 	// store data
-	err := u.StoreM(jsonData, "ckdc_params")
+	err = u.StoreM(jsonData, "ckdc_params")
 	if err != nil {
 		log.Error().Msg("u.StoreM")
 		return nil, err
@@ -200,12 +224,62 @@ func DeriveKeyIvCATS(toBshared map[string]string) (map[string]string, error) {
 	return jsonData, nil
 }
 
+// ProcessSF checks the server finished mac against the transcript hash H7 under
+// the finished key derived from SHTS and stores the public SF verification data
 func ProcessSF(toBshared map[string]string) error {
 
-	// compute missing values for key verification
-	HS, _ := hex.DecodeString(toBshared["HS"])
-	intermediateHashHSopad := tls.PIntermediateHashHSopad(HS)
-	intermediateHashHSipad := tls.PIntermediateHashHSipad(HS)
+	suite, err := suiteOf(toBshared)
+	if err != nil {
+		log.Error().Err(err).Msg("suiteOf(toBshared)")
+		return err
+	}
+
+	// server finished record caught in Read
+	SHTS, _ := hex.DecodeString(toBshared["SHTS"])
+	aead, iv, err := suite.handshakeAEAD(SHTS)
+	if err != nil {
+		log.Error().Err(err).Msg("suite.handshakeAEAD(SHTS)")
+		return err
+	}
+	nonce, err := recordNonce(iv, toBshared["recordHashSF"])
+	if err != nil {
+		log.Error().Err(err).Msg("recordNonce(iv, recordHashSF)")
+		return err
+	}
+	ciphertext, _ := hex.DecodeString(toBshared["ciphertext"])
+	additionalData, _ := hex.DecodeString(toBshared["additionalData"])
+	payload, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		log.Error().Err(err).Msg("aead.Open(SF)")
+		return err
+	}
+
+	// finished message with verify data of hash length
+	size := suite.hash().Size()
+	contentEnd, contentType := recordContent(payload)
+	if contentEnd != handshakeHeaderLen+size || contentType != recordTypeHandshake || payload[0] != typeFinished {
+		err = errors.New("SF record holds no server finished message")
+		log.Error().Err(err).Msg("aead.Open(SF)")
+		return err
+	}
+	verifyData := payload[handshakeHeaderLen:contentEnd]
+
+	// verify data is the mac of the transcript hash up to the
+	// CertificateVerify (H7) under the finished key
+	H7, _ := hex.DecodeString(toBshared["H7"])
+	if len(H7) != size {
+		err = fmt.Errorf("transcript hash H7 must be %d bytes for %s", size, suite.name)
+		log.Error().Err(err).Msg("hex.DecodeString(H7)")
+		return err
+	}
+	finishedKey := suite.expandLabel(SHTS, "finished", nil, size)
+	mac := hmac.New(suite.hash, finishedKey)
+	mac.Write(H7)
+	if !hmac.Equal(mac.Sum(nil), verifyData) {
+		err = errors.New("server finished verify data does not match the transcript")
+		log.Error().Err(err).Msg("hmac.Equal(verifyData)")
+		return err
+	}
 
 	// add values to json map
 	jsonData := make(map[string]string)
@@ -213,14 +287,19 @@ func ProcessSF(toBshared map[string]string) error {
 	jsonData["H2"] = toBshared["H2"]
 	jsonData["H3"] = toBshared["H3"]
 	jsonData["H7"] = toBshared["H7"]
-	jsonData["intermediateHashHSopad"] = hex.EncodeToString(intermediateHashHSopad)
-	jsonData["intermediateHashHSipad"] = hex.EncodeToString(intermediateHashHSipad)
 	jsonData["recordHashSF"] = toBshared["recordHashSF"]
 	jsonData["additionalData"] = toBshared["additionalData"]
 	jsonData["ciphertext"] = toBshared["ciphertext"]
+	jsonData["verifyData"] = hex.EncodeToString(verifyData)
+	jsonData["cipher_suite"] = suite.name
+
+	// intermediate hashes of the zk key derivation
+	HS, _ := hex.DecodeString(toBshared["HS"])
+	jsonData["intermediateHashHSopad"] = hex.EncodeToString(tls.PIntermediateHashHSopad(HS))
+	jsonData["intermediateHashHSipad"] = hex.EncodeToString(tls.PIntermediateHashHSipad(HS))
 
 	// store data
-	err := u.StoreM(jsonData, "sf_public")
+	err = u.StoreM(jsonData, "sf_public")
 	if err != nil {
 		log.Error().Msg("u.StoreM")
 		return err
@@ -264,6 +343,30 @@ func Read() (map[string]string, error) {
 				log.Error().Err(err).Msg("json.Unmarshal(v, &secrets)")
 				return nil, err
 			}
+		} else if k == "session" {
+
+			// negotiated session parameters
+			session := make(map[string]string)
+			err = json.Unmarshal(v, &session)
+			if err != nil {
+				log.Error().Err(err).Msg("json.Unmarshal(v, &session)")
+				return nil, err
+			}
+			for sk, sv := range session {
+				twoBshared[sk] = sv
+			}
+		} else if k == "certificates" || k == "handshake" {
+
+			// server identity, keyed by entry and index
+			entries := make(map[string]string)
+			err = json.Unmarshal(v, &entries)
+			if err != nil {
+				log.Error().Err(err).Msg("json.Unmarshal(v, &entries)")
+				return nil, err
+			}
+			for ek, ev := range entries {
+				twoBshared[k+"_"+ek] = ev
+			}
 		} else {
 
 			// parse records
@@ -274,8 +377,14 @@ func Read() (map[string]string, error) {
 				return nil, err
 			}
 
-			// catch SF record
+			// catch SF record by type, its sequence number depends on the
+			// handshake messages of the server, e.g. a CertificateRequest
 			if rm["typ"] == "SF" {
+				if _, ok := twoBshared["recordHashSF"]; ok {
+					err = errors.New("session holds more than one SF record")
+					log.Error().Err(err).Str("sequence", k).Msg("rm[\"typ\"]")
+					return nil, err
+				}
 				twoBshared["ciphertext"] = rm["ciphertext"]
 				twoBshared["additionalData"] = rm["additionalData"]
 				twoBshared["recordHashSF"] = k
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -16,89 +17,325 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func ParsePlaintextWithPolicy(rps map[string]map[string]string) error {
+func ParsePlaintextWithPolicy(rps map[string]map[string]string, policy p.Policy) error {
 
-	// init values
-	found := false
+	jsonDataPublic, jsonDataPrivate, err := policyInput(rps, policy)
+	if err != nil {
+		return err
+	}
 
-	// get policy
-	policy, err := p.New()
+	err = u.StoreMM(jsonDataPublic, "recorddata_public_input")
 	if err != nil {
 		return err
 	}
 
-	jsonData := make(map[string]string)
+	err = u.StoreMM(jsonDataPrivate, "recorddata_private_input")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// policyInput returns the public and private record data input per predicate index.
+// Every predicate is part of the input, whether a predicate of a disjunction
+// holds is left to the circuit and not revealed by the input.
+func policyInput(rps map[string]map[string]string, policy p.Policy) (map[string]map[string]string, map[string]map[string]string, error) {
+
+	// public and private input per predicate index
+	jsonDataPublic := make(map[string]map[string]string)
+	jsonDataPrivate := make(map[string]map[string]string)
+
+	// binds the proof to the evaluated policy
+	commitment, err := policy.Commitment()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// http response across all server records
+	stream, err := newResponseStream(rps)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	combinator := policy.CombinatorOrDefault()
+	holding := 0
+	for i, predicate := range policy.Flatten() {
+
+		jsonData, jsonData2, holds, err := parsePredicate(predicate, stream)
+		if err != nil {
+			return nil, nil, fmt.Errorf("predicate %d: %w", i, err)
+		}
+		if holds {
+			holding++
+		} else if combinator != p.CombinatorOr {
+			return nil, nil, fmt.Errorf("predicate %d: value does not satisfy predicate", i)
+		}
+
+		jsonData["combinator"] = combinator
+		jsonData["policy_commitment"] = commitment
+		jsonDataPublic[strconv.Itoa(i)] = jsonData
+		jsonDataPrivate[strconv.Itoa(i)] = jsonData2
+	}
+
+	// evaluate policy locally, proof generation fails otherwise
+	if holding == 0 {
+		return nil, nil, errors.New("no predicate of the policy holds")
+	}
+	return jsonDataPublic, jsonDataPrivate, nil
+}
+
+// parsePredicate locates the value of a single policy predicate in the response stream and
+// returns the public and private circuit input and if the value satisfies the predicate
+func parsePredicate(policy p.Policy, stream responseStream) (map[string]string, map[string]string, bool, error) {
+
+	// constraint values at the precision of the policy value type
+	jsonData, err := constraintInput(policy)
+	if err != nil {
+		return nil, nil, false, err
+	}
 	jsonData2 := make(map[string]string)
 
-	// parse plaintext chunks
-	// record has SR content found in session_params_13
-	for _, record := range rps {
+	// response of the exchange holding the value
+	responses, err := splitResponses(stream.text)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	response, err := stream.exchangeResponse(responses, policy.Exchange)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	responseText := stream.text[response.Start:response.End]
+
+	// check if substring exists
+	// done on the full response because chunking might prevent substring match detection
+	var startIdxAreaOfInterest, endIdxAreaOfInterest int
+	var framing [][2]int
+	var value p.Value
+	substring := policy.Substring
+	if policy.Selector != "" {
+		// resolve selector on the decoded http body and match on the quoted key
+		body, err := decodeBody(stream.text, response)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		match, err := resolveSelector(body.Text, policy)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		valueStartIdx := match.ValueStart
+		if body.Text[valueStartIdx] == '"' {
+			valueStartIdx++
+		}
 
-		// loop over plaintext 16b chunks
-		plaintextBytes, _ := hex.DecodeString(record["payload"])
-		plaintext := string(plaintextBytes)
+		// find true value boundaries according to the policy value type
+		value, err = policy.LocateValue(body.Text, valueStartIdx)
+		if err != nil {
+			return nil, nil, false, err
+		}
 
-		// to capture ciphertext_chunks if match found
-		ciphertextBytes, _ := hex.DecodeString(record["ciphertext"])
+		// map key, value and terminating byte back to response offsets,
+		// value offsets continue the key offset without the chunk framing
+		startIdxAreaOfInterest, endIdxAreaOfInterest, framing, err = body.streamRange(stream.text, match.KeyStart, value.End+1)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		shift := startIdxAreaOfInterest - match.KeyStart
+		value.Start += shift
+		value.End += shift
+		// the circuit proves the key at the offset resolved by the scanner,
+		// the key text may occur elsewhere in the response
+		substring = body.Text[match.KeyStart:match.KeyEnd]
+	} else {
+		startIdxAreaOfInterest = strings.Index(responseText, policy.Substring)
+		if startIdxAreaOfInterest < 0 {
+			return nil, nil, false, errors.New("could not find any substring match")
+		}
+		startIdxAreaOfInterest += response.Start
+		valueStartIdx := startIdxAreaOfInterest + len(policy.Substring) + policy.ValueStartIdxAfterSS - 1
 
-		// check if substring exists
-		// done on full plaintext because chunking might prevent substring match detection
-		var startIdxAreaOfInterest, endIdxAreaOfInterest, chunkIndex int
-		found = strings.Contains(plaintext, policy.Substring)
-		if found {
-			startIdxAreaOfInterest = strings.Index(plaintext, policy.Substring)
-			endIdxAreaOfInterest = startIdxAreaOfInterest + len(policy.Substring) + policy.ValueStartIdxAfterSS + policy.ValueLength
-		} else {
-			return errors.New("could not find any substring match")
-		}
-
-		// area of interest used to identify the number of chunks that must be decrypted
-		numb_chunks := len(plaintextBytes) / 16
-		sizeAreaOfInterest := endIdxAreaOfInterest - startIdxAreaOfInterest
-		for i := 0; i < numb_chunks; i++ {
-			chunkEnd := (i + 1) * 16
-			if chunkEnd >= startIdxAreaOfInterest {
-				// set chunk index
-				chunkIndex = i
-				// exist loop
-				i = numb_chunks
-			}
+		// find true value boundaries according to the policy value type
+		value, err = policy.LocateValue(stream.text[:response.End], valueStartIdx)
+		if err != nil {
+			return nil, nil, false, err
 		}
-		number_chunks := (((startIdxAreaOfInterest - (chunkIndex * 16)) + sizeAreaOfInterest) / 16) + 1
-		start_idx_chunks := startIdxAreaOfInterest - (chunkIndex * 16)
 
-		// public input for record data proof
-		jsonData["chunk_index"] = strconv.Itoa(chunkIndex + 2)
-		jsonData["substring"] = policy.Substring
-		jsonData["substring_start_idx"] = strconv.Itoa(startIdxAreaOfInterest)
-		jsonData["number_chunks"] = strconv.Itoa(number_chunks)
-		jsonData["size_area_of_interest"] = strconv.Itoa(sizeAreaOfInterest)
-		jsonData["size_value"] = strconv.Itoa(policy.ValueLength)
-		jsonData["cipher_chunks"] = hex.EncodeToString(ciphertextBytes[chunkIndex*16 : (chunkIndex+number_chunks)*16])
-		jsonData2["plain_chunks"] = hex.EncodeToString(plaintextBytes[chunkIndex*16 : (chunkIndex+number_chunks)*16])
-		// chunk level substring start index
-		jsonData["substring_start"] = strconv.Itoa(start_idx_chunks)
-		jsonData["substring_end"] = strconv.Itoa(len(policy.Substring) + start_idx_chunks)
-		jsonData["value_start"] = strconv.Itoa(start_idx_chunks + sizeAreaOfInterest - policy.ValueLength - 1)
-		jsonData["value_end"] = strconv.Itoa(start_idx_chunks + sizeAreaOfInterest - 1)
-		log.Debug().Str("string", string(plaintextBytes[startIdxAreaOfInterest:startIdxAreaOfInterest+sizeAreaOfInterest])).Msg("area of interest")
+		// area of interest includes the byte terminating the value
+		endIdxAreaOfInterest = value.End + 1
 	}
 
-	err = u.StoreM(jsonData, "recorddata_public_input")
+	// evaluate predicate locally, proof generation fails otherwise
+	holds, err := policy.Holds(value)
 	if err != nil {
-		return err
+		return nil, nil, false, err
 	}
 
-	err = u.StoreM(jsonData2, "recorddata_private_input")
+	// records holding the area of interest, values may straddle two records
+	parts, err := stream.locate(startIdxAreaOfInterest, endIdxAreaOfInterest)
 	if err != nil {
-		return err
+		return nil, nil, false, err
 	}
 
-	return nil
+	// area of interest used to identify the chunks that must be decrypted,
+	// chunks of a straddling value continue in the next record
+	first := parts[0]
+	chunkIndex := first.start / chunkSize
+	chunksStart := first.segment.start + chunkIndex*chunkSize
+	cipherChunks, plainChunks, numberChunks := recordChunks(first, chunkIndex, len(parts) > 1)
+	err = checkChunks(numberChunks)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	sizeAreaOfInterest := endIdxAreaOfInterest - startIdxAreaOfInterest
+
+	// public input for record data proof
+	jsonData["sequence"] = first.segment.sequence
+	jsonData["chunk_index"] = strconv.Itoa(chunkIndex + firstCounter)
+	jsonData["substring"] = substring
+	jsonData["substring_start_idx"] = strconv.Itoa(first.start)
+	jsonData["number_chunks"] = strconv.Itoa(numberChunks)
+	jsonData["size_area_of_interest"] = strconv.Itoa(sizeAreaOfInterest)
+	jsonData["size_value"] = strconv.Itoa(value.End - value.Start)
+	jsonData["value_constraint"] = policy.ValueConstraint
+	jsonData["value_type"] = policy.Type()
+	jsonData["value_skip"] = joinInts(value.Skip)
+	jsonData["value_shift"] = strconv.Itoa(value.Shift)
+	jsonData["cipher_chunks"] = hex.EncodeToString(cipherChunks)
+	jsonData2["plain_chunks"] = hex.EncodeToString(plainChunks)
+
+	// continuation of a value straddling the record boundary
+	if len(parts) > 1 {
+		next := parts[1]
+		cipherChunks, plainChunks, numberChunks = recordChunks(next, 0, false)
+		err = checkChunks(numberChunks)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		jsonData["sequence_next"] = next.segment.sequence
+		jsonData["chunk_index_next"] = strconv.Itoa(firstCounter)
+		jsonData["number_chunks_next"] = strconv.Itoa(numberChunks)
+		jsonData["size_area_of_interest_next"] = strconv.Itoa(next.end)
+		jsonData["cipher_chunks_next"] = hex.EncodeToString(cipherChunks)
+		jsonData2["plain_chunks_next"] = hex.EncodeToString(plainChunks)
+	}
+
+	// chunk level substring start index, relative to the first chunk
+	// and continued across the record boundary for straddling values
+	startIdxChunks := startIdxAreaOfInterest - chunksStart
+	jsonData["substring_start"] = strconv.Itoa(startIdxChunks)
+	jsonData["substring_end"] = strconv.Itoa(len(substring) + startIdxChunks)
+	jsonData["framing"] = joinRanges(framing, chunksStart)
+	jsonData["value_start"] = strconv.Itoa(value.Start - chunksStart)
+	jsonData["value_end"] = strconv.Itoa(value.End - chunksStart)
+	// chunk level bytes compared against the expected string
+	if !policy.Numeric() {
+		matchStart, matchEnd, err := policy.MatchRange(value)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		jsonData["match_start"] = strconv.Itoa(matchStart - chunksStart)
+		jsonData["match_end"] = strconv.Itoa(matchEnd - chunksStart)
+	}
+	log.Debug().Str("string", stream.text[startIdxAreaOfInterest:endIdxAreaOfInterest]).Msg("area of interest")
+	log.Debug().Str("number", value.Number).Str("constraint", policy.ValueConstraint).Msg("policy value")
+
+	return jsonData, jsonData2, holds, nil
+}
+
+// recordChunks returns the cipher and plain chunks of a record part starting at chunk
+// index, chunks of a part continued in the next record end with the record content
+func recordChunks(part recordPart, chunkIndex int, continued bool) ([]byte, []byte, int) {
+	start := chunkIndex * chunkSize
+	end := part.segment.contentEnd
+	if !continued {
+		// full chunks up to the end of the part, the last chunk of a record may be partial
+		end = ((part.end-1)/chunkSize + 1) * chunkSize
+		if end > len(part.segment.payload) {
+			end = len(part.segment.payload)
+		}
+	}
+	numberChunks := (end - start + chunkSize - 1) / chunkSize
+	return part.segment.ciphertext[start:end], part.segment.payload[start:end], numberChunks
+}
+
+// constraintInput returns the public constraint values of a predicate,
+// thresholds and set members are normalized to the policy precision
+func constraintInput(policy p.Policy) (map[string]string, error) {
+
+	jsonData := make(map[string]string)
+	if !policy.Numeric() {
+		// expected string in clear or as hash
+		_, expected, err := policy.Expected()
+		if err != nil {
+			return nil, err
+		}
+		if policy.ExpectedHash != "" {
+			jsonData["expected_hash"] = strings.ToLower(policy.ExpectedHash)
+		} else {
+			jsonData["expected"] = hex.EncodeToString(expected)
+		}
+		return jsonData, nil
+	}
+
+	// non digit bytes the circuit may skip inside the value and the decimal precision
+	jsonData["separators"] = hex.EncodeToString(policy.Separators())
+	jsonData["scale"] = strconv.Itoa(policy.Scale)
+
+	switch policy.ValueConstraint {
+	case "BETWEEN":
+		lower, upper, err := policy.NormalizeBounds()
+		if err != nil {
+			return nil, err
+		}
+		jsonData["threshold"] = lower
+		jsonData["threshold_upper"] = upper
+	case "IN", "NOT_IN":
+		members, err := policy.NormalizeValues()
+		if err != nil {
+			return nil, err
+		}
+		jsonData["value_set"] = strings.Join(members, ",")
+	default:
+		threshold, err := policy.NormalizeThreshold()
+		if err != nil {
+			return nil, err
+		}
+		jsonData["threshold"] = threshold
+		// the verifier checks freshness thresholds against its clock
+		if policy.MaxAge > 0 {
+			jsonData["max_age"] = strconv.Itoa(policy.MaxAge)
+		}
+	}
+	return jsonData, nil
+}
+
+// joinRanges encodes ranges relative to offset as comma separated list of start-end pairs
+func joinRanges(ranges [][2]int, offset int) string {
+	s := make([]string, len(ranges))
+	for i, r := range ranges {
+		s[i] = strconv.Itoa(r[0]-offset) + "-" + strconv.Itoa(r[1]-offset)
+	}
+	return strings.Join(s, ",")
+}
+
+// joinInts encodes a slice of indices as comma separated list
+func joinInts(idx []int) string {
+	s := make([]string, len(idx))
+	for i, v := range idx {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
 }
 
 func RecordTagZkInput(sParams map[string]string, rps map[string]map[string]string) error {
 
+	// record cipher of the negotiated suite
+	_, err := suiteOf(sParams)
+	if err != nil {
+		log.Error().Err(err).Msg("suiteOf(sParams)")
+		return err
+	}
+
 	// get data and init aes
 	keyBytes, _ := hex.DecodeString(sParams["keySapp"])
 	ivBytes, _ := hex.DecodeString(sParams["ivSapp"])
@@ -117,12 +354,14 @@ func RecordTagZkInput(sParams map[string]string, rps map[string]map[string]strin
 		// collects output
 		jsonData := make(map[string]string)
 
-		// gcm_nonce is iv || counter=0
-		// todo: concatenate sequence number behind ivBytes in gcm_nonce
-		var gcm_nonce [16]byte
-		if sequence == "0000000000000000" {
-			copy(gcm_nonce[:], ivBytes)
+		// gcm_nonce is the record nonce, iv xor sequence number, || counter
+		nonce, err := recordNonce(ivBytes, sequence)
+		if err != nil {
+			log.Error().Err(err).Msg("recordNonce(ivBytes, sequence)")
+			return err
 		}
+		var gcm_nonce [16]byte
+		copy(gcm_nonce[:], nonce)
 
 		// must be set if nonce comes in default size equal to 12
 		gcm_nonce[15] = 1
@@ -140,6 +379,7 @@ func RecordTagZkInput(sParams map[string]string, rps map[string]map[string]strin
 		aes.Encrypt(ecbk[:], ecbk[:])
 
 		jsonData["ECBK"] = hex.EncodeToString(ecbk[:])
+		jsonData["nonce"] = hex.EncodeToString(nonce)
 
 		jsonDataPublic[sequence] = jsonData
 	}
@@ -214,6 +454,16 @@ func ReadServerRecords() (map[string]map[string]string, error) {
 		return nil, err
 	}
 
+	// negotiated session parameters
+	session := make(map[string]string)
+	if v, ok := objmap["session"]; ok {
+		err = json.Unmarshal(v, &session)
+		if err != nil {
+			log.Error().Err(err).Msg("json.Unmarshal(v, &session)")
+			return nil, err
+		}
+	}
+
 	// catch server record data
 	recordPerSequence := make(map[string]map[string]string)
 	for k, v := range objmap {
@@ -234,6 +484,8 @@ func ReadServerRecords() (map[string]map[string]string, error) {
 				valuesOfInterest["ciphertext"] = keyValues["ciphertext"]
 				valuesOfInterest["recordHashSF"] = k
 				valuesOfInterest["payload"] = keyValues["payload"]
+				valuesOfInterest["cipher_suite"] = session["cipher_suite"]
+				valuesOfInterest["redirects"] = session["redirects"]
 
 				// record layer data
 				recordPerSequence[k] = valuesOfInterest
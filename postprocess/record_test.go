@@ -0,0 +1,231 @@
+package postprocess
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	p "client/policy"
+)
+
+// testRecords returns server records holding the given record contents in order,
+// payloads end with the inner content type and ciphertexts are random looking bytes
+func testRecords(contents ...string) map[string]map[string]string {
+	rps := make(map[string]map[string]string)
+	for i, content := range contents {
+		payload := append([]byte(content), 0x17)
+		ciphertext := make([]byte, len(payload)+16)
+		for j := range ciphertext {
+			ciphertext[j] = byte(j*7 + i)
+		}
+		rps[fmt.Sprintf("%016x", i)] = map[string]string{
+			"payload":    hex.EncodeToString(payload),
+			"ciphertext": hex.EncodeToString(ciphertext),
+		}
+	}
+	return rps
+}
+
+// testResponse returns an http response with content length framed body
+func testResponse(body string) string {
+	return "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestParsePredicate(t *testing.T) {
+
+	// value continued in the next record
+	straddling := testResponse(`{"balance":38002}`)
+
+	tests := []struct {
+		name     string
+		contents []string
+		policy   p.Policy
+		// expected value bytes at the chunk level value offsets
+		value string
+		holds bool
+		err   string
+	}{
+		{"selector", []string{testResponse(`{"balance":38002}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "38002", true, ""},
+		{"key text elsewhere in response", []string{testResponse(`{"old":{"balance":1},"balance":38002}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "38002", true, ""},
+		{"key text in header", []string{strings.Replace(testResponse(`{"balance":5}`), "Content-Type", "X-Key: \"balance\":9\r\nContent-Type", 1)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "LT", ThresholdValue: "6"}, "5", true, ""},
+		{"value does not hold", []string{testResponse(`{"balance":38001}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "38001", false, ""},
+		{"substring", []string{testResponse(`{"balance":38002}`)},
+			p.Policy{Substring: `"balance":`, ValueStartIdxAfterSS: 1, ValueConstraint: "GE", ThresholdValue: "38002"}, "38002", true, ""},
+		{"straddling value", []string{straddling[:len(straddling)-4], straddling[len(straddling)-4:]},
+			p.Policy{Substring: `"balance":`, ValueStartIdxAfterSS: 1, ValueConstraint: "GT", ThresholdValue: "1"}, "38002", true, ""},
+		{"key straddling a chunk boundary", []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n{\"bal\r\nc\r\nance\":38002}\r\n0\r\n\r\n"},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "38002", true, ""},
+		{"value straddling a chunk boundary", []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nd\r\n{\"balance\":38\r\n4\r\n002}\r\n0\r\n\r\n"},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "38002", true, ""},
+		{"chunk extension in area of interest", []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nd\r\n{\"balance\":38\r\n4;x=1\r\n002}\r\n0\r\n\r\n"},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "", false, "chunk extensions"},
+		{"value in one chunk", []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n1\r\n{\r\n10\r\n\"balance\":38002}\r\n0\r\n\r\n"},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, "38002", true, ""},
+
+		{"missing key", []string{testResponse(`{"amount":1}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "1"}, "", false, "not found"},
+		{"duplicate key", []string{testResponse(`{"balance":1,"balance":2}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "1"}, "", false, "occurs more than once"},
+		{"missing substring", []string{testResponse(`{"amount":1}`)},
+			p.Policy{Substring: `"balance":`, ValueStartIdxAfterSS: 1, ValueConstraint: "GT", ThresholdValue: "1"}, "", false, "could not find any substring match"},
+		{"value exceeds chunk budget", []string{testResponse(`{"balance":` + strings.Repeat("1", 60) + `}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "1"}, "", false, "the circuit decrypts 4 per record"},
+		{"missing exchange", []string{testResponse(`{"balance":1}`)},
+			p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "1", Exchange: 1}, "", false, "exchange 1 not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := newResponseStream(testRecords(tt.contents...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			public, private, holds, err := parsePredicate(tt.policy, stream)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if holds != tt.holds {
+				t.Errorf("holds %v, want %v", holds, tt.holds)
+			}
+
+			if straddles := public["sequence_next"] != ""; straddles != (len(tt.contents) > 1) {
+				t.Errorf("sequence_next %q for %d records", public["sequence_next"], len(tt.contents))
+			}
+
+			// chunk level offsets point into the decrypted chunks of both records
+			// without the chunk framing
+			plain, _ := hex.DecodeString(private["plain_chunks"])
+			next, _ := hex.DecodeString(private["plain_chunks_next"])
+			plain = unframe(append(plain, next...), public["framing"])
+			start, _ := strconv.Atoi(public["value_start"])
+			end, _ := strconv.Atoi(public["value_end"])
+			if value := string(plain[start:end]); value != tt.value {
+				t.Errorf("value %q, want %q", value, tt.value)
+			}
+			sss, _ := strconv.Atoi(public["substring_start"])
+			if key := string(plain[sss : sss+len(public["substring"])]); key != public["substring"] {
+				t.Errorf("key %q at substring_start, want %q", key, public["substring"])
+			}
+		})
+	}
+}
+
+// unframe removes the chunk framing ranges from the plaintext chunks
+func unframe(plain []byte, framing string) []byte {
+	if framing == "" {
+		return plain
+	}
+	ranges := strings.Split(framing, ",")
+	for i := len(ranges) - 1; i >= 0; i-- {
+		var start, end int
+		fmt.Sscanf(ranges[i], "%d-%d", &start, &end)
+		plain = append(plain[:start:start], plain[end:]...)
+	}
+	return plain
+}
+
+func TestResponseStreamSkipsPostHandshakeRecords(t *testing.T) {
+
+	// NewSessionTicket between two records of a response holding a straddling value
+	response := testResponse(`{"balance":38002}`)
+	rps := testRecords(response[:len(response)-4], "", response[len(response)-4:])
+	ticket := append([]byte{4, 0, 0, 1, 0}, 0x16, 0, 0)
+	rps["0000000000000001"]["payload"] = hex.EncodeToString(ticket)
+
+	stream, err := newResponseStream(rps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.text != response {
+		t.Fatalf("stream %q, want %q", stream.text, response)
+	}
+
+	public, private, holds, err := parsePredicate(p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !holds {
+		t.Error("predicate does not hold")
+	}
+	if public["sequence"] != "0000000000000000" || public["sequence_next"] != "0000000000000002" {
+		t.Errorf("sequences %q and %q, want the application data records", public["sequence"], public["sequence_next"])
+	}
+	plain, _ := hex.DecodeString(private["plain_chunks"])
+	next, _ := hex.DecodeString(private["plain_chunks_next"])
+	plain = append(plain, next...)
+	start, _ := strconv.Atoi(public["value_start"])
+	end, _ := strconv.Atoi(public["value_end"])
+	if value := string(plain[start:end]); value != "38002" {
+		t.Errorf("value %q, want 38002", value)
+	}
+
+	// sessions without any application data
+	_, err = newResponseStream(map[string]map[string]string{"0000000000000000": rps["0000000000000001"]})
+	if err == nil || !strings.Contains(err.Error(), "no application data record") {
+		t.Errorf("expected error on session without application data, got %v", err)
+	}
+}
+
+func TestPolicyInput(t *testing.T) {
+
+	records := testRecords(testResponse(`{"balance":38002,"age":17}`))
+	balance := p.Policy{Selector: "$.balance", ValueConstraint: "GT", ThresholdValue: "38001"}
+	adult := p.Policy{Selector: "$.age", ValueConstraint: "GE", ThresholdValue: "18"}
+	missing := p.Policy{Selector: "$.income", ValueConstraint: "GT", ThresholdValue: "1"}
+
+	tests := []struct {
+		name   string
+		policy p.Policy
+		// number of predicates in the input
+		predicates int
+		err        string
+	}{
+		{"single predicate", balance, 1, ""},
+		{"and holds", p.Policy{Combinator: "AND", Predicates: []p.Policy{balance, balance}}, 2, ""},
+		{"and fails", p.Policy{Combinator: "AND", Predicates: []p.Policy{balance, adult}}, 0, "predicate 1: value does not satisfy predicate"},
+		{"or keeps failing predicate", p.Policy{Combinator: "OR", Predicates: []p.Policy{adult, balance}}, 2, ""},
+		{"or fails", p.Policy{Combinator: "OR", Predicates: []p.Policy{adult, adult}}, 0, "no predicate of the policy holds"},
+		{"or returns selector error", p.Policy{Combinator: "OR", Predicates: []p.Policy{balance, missing}}, 0, "predicate 1: selector"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			public, private, err := policyInput(records, tt.policy)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(public) != tt.predicates || len(private) != tt.predicates {
+				t.Fatalf("%d public and %d private predicates, want %d", len(public), len(private), tt.predicates)
+			}
+			for i, input := range public {
+				if input["combinator"] != tt.policy.CombinatorOrDefault() {
+					t.Errorf("predicate %s combinator %q", i, input["combinator"])
+				}
+				// whether a predicate holds is not part of the input
+				for k := range input {
+					if strings.Contains(k, "holds") {
+						t.Errorf("predicate %s reveals %s", i, k)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package postprocess
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// recordSegment locates the content of a server record in the response stream
+type recordSegment struct {
+	sequence string
+	// stream offset of the first content byte
+	start int
+	// decrypted payload and ciphertext of the record, the content
+	// ends before the inner content type and padding
+	payload    []byte
+	ciphertext []byte
+	contentEnd int
+}
+
+// responseStream is the http response reassembled from the
+// content of all server records in order of their sequence number
+type responseStream struct {
+	text     string
+	segments []recordSegment
+	// indices of responses which redirected to the next exchange of the session
+	redirects map[int]bool
+}
+
+// recordPart is the range of a stream range within a single record
+type recordPart struct {
+	segment recordSegment
+	// record offsets, end is exclusive
+	start int
+	end   int
+}
+
+// newResponseStream concatenates the server records of a response
+func newResponseStream(rps map[string]map[string]string) (responseStream, error) {
+
+	// records in order of sequence number
+	sequences := make([]string, 0, len(rps))
+	for sequence := range rps {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool {
+		a, _ := strconv.ParseUint(sequences[i], 16, 64)
+		b, _ := strconv.ParseUint(sequences[j], 16, 64)
+		return a < b
+	})
+	if len(sequences) == 0 {
+		return responseStream{}, errors.New("no server record in session")
+	}
+
+	var stream responseStream
+	var text strings.Builder
+	for _, sequence := range sequences {
+		record := rps[sequence]
+
+		_, err := suiteOf(record)
+		if err != nil {
+			return responseStream{}, err
+		}
+
+		payload, _ := hex.DecodeString(record["payload"])
+		ciphertext, _ := hex.DecodeString(record["ciphertext"])
+		if len(ciphertext) < len(payload) {
+			return responseStream{}, fmt.Errorf("record %s ciphertext shorter than payload", sequence)
+		}
+
+		// post handshake messages such as NewSessionTicket and alerts
+		// share the record layer but are no part of the response
+		contentEnd, contentType := recordContent(payload)
+		if contentType != recordTypeApplicationData {
+			log.Debug().Str("sequence", sequence).Int("type", int(contentType)).Msg("skipped non application data record.")
+			continue
+		}
+		segment := recordSegment{
+			sequence:   sequence,
+			start:      text.Len(),
+			payload:    payload,
+			ciphertext: ciphertext,
+			contentEnd: contentEnd,
+		}
+		text.Write(payload[:segment.contentEnd])
+		stream.segments = append(stream.segments, segment)
+	}
+	if len(stream.segments) == 0 {
+		return responseStream{}, errors.New("no application data record in session")
+	}
+	stream.text = text.String()
+
+	// followed redirects are recorded per session
+	stream.redirects = make(map[int]bool)
+	for _, index := range strings.Split(rps[sequences[0]]["redirects"], ",") {
+		if index == "" {
+			continue
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return responseStream{}, fmt.Errorf("invalid redirect index %q", index)
+		}
+		stream.redirects[i] = true
+	}
+	return stream, nil
+}
+
+// exchangeResponse returns the final response of the exchange with the given index,
+// responses of redirects followed on the session do not count as exchanges
+func (s responseStream) exchangeResponse(responses []httpResponse, exchange int) (httpResponse, error) {
+	count := 0
+	for i, response := range responses {
+		if s.redirects[i] {
+			continue
+		}
+		if count == exchange {
+			return response, nil
+		}
+		count++
+	}
+	return httpResponse{}, fmt.Errorf("exchange %d not found, session holds %d exchanges", exchange, count)
+}
+
+// locate splits the stream range [start, end) into the records holding it
+func (s responseStream) locate(start int, end int) ([]recordPart, error) {
+	var parts []recordPart
+	for _, segment := range s.segments {
+		segmentEnd := segment.start + segment.contentEnd
+		if end <= segment.start || start >= segmentEnd {
+			continue
+		}
+		part := recordPart{segment: segment, start: 0, end: segment.contentEnd}
+		if start > segment.start {
+			part.start = start - segment.start
+		}
+		if end < segmentEnd {
+			part.end = end - segment.start
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("area of interest exceeds the response records")
+	}
+	if len(parts) > 2 {
+		return nil, errors.New("area of interest spans more than two records")
+	}
+	return parts, nil
+}
+
+// recordContent returns the length of the record content and the inner content type,
+// which is the last non zero byte of the payload followed by zero padding
+func recordContent(payload []byte) (int, byte) {
+	end := len(payload)
+	for end > 0 && payload[end-1] == 0 {
+		end--
+	}
+	if end == 0 {
+		return 0, 0
+	}
+	return end - 1, payload[end-1]
+}
@@ -0,0 +1,121 @@
+package postprocess
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strconv"
+)
+
+// cipher suite negotiated if the session file does not name one
+const defaultCipherSuite = "TLS_AES_128_GCM_SHA256"
+
+// tls 1.3 cipher suite parameters of the key schedule and record layer
+type cipherSuite struct {
+	name   string
+	hash   func() hash.Hash
+	keyLen int
+	ivLen  int
+}
+
+// supported tls 1.3 cipher suites by name
+var cipherSuites = map[string]cipherSuite{
+	"TLS_AES_128_GCM_SHA256": {name: "TLS_AES_128_GCM_SHA256", hash: sha256.New, keyLen: 16, ivLen: 12},
+}
+
+// suiteOf returns the cipher suite recorded in the session secrets
+func suiteOf(toBshared map[string]string) (cipherSuite, error) {
+	name := toBshared["cipher_suite"]
+	if name == "" {
+		name = defaultCipherSuite
+	}
+	suite, ok := cipherSuites[name]
+	if !ok {
+		return cipherSuite{}, fmt.Errorf("unsupported cipher suite %s", name)
+	}
+	return suite, nil
+}
+
+// plaintext is split into aes blocks, the record data circuit decrypts
+// four of them per record
+const (
+	chunkSize         = 16
+	circuitChunkBytes = 4 * chunkSize
+)
+
+// checkChunks fails if the area of interest of a record needs more chunks than the circuit decrypts
+func checkChunks(numberChunks int) error {
+	if numberChunks*chunkSize > circuitChunkBytes {
+		return fmt.Errorf("area of interest needs %d chunks of %d bytes, the circuit decrypts %d per record", numberChunks, chunkSize, circuitChunkBytes/chunkSize)
+	}
+	return nil
+}
+
+// keystream counter of the first payload block, aes-gcm reserves counter 1 for the tag mask
+const firstCounter = 2
+
+// recordNonce returns the per record nonce, the iv xor the
+// big endian sequence number padded to the iv length
+func recordNonce(iv []byte, sequence string) ([]byte, error) {
+	seq, err := strconv.ParseUint(sequence, 16, 64)
+	if err != nil || len(iv) < 8 {
+		return nil, fmt.Errorf("invalid record sequence %q", sequence)
+	}
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+	}
+	return nonce, nil
+}
+
+// aead returns the record protection of the suite under key
+func (s cipherSuite) aead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// handshakeAEAD returns the record protection and iv of the server handshake
+// records, derived from the server handshake traffic secret
+func (s cipherSuite) handshakeAEAD(SHTS []byte) (cipher.AEAD, []byte, error) {
+	if len(SHTS) != s.hash().Size() {
+		return nil, nil, fmt.Errorf("server handshake traffic secret must be %d bytes for %s", s.hash().Size(), s.name)
+	}
+	aead, err := s.aead(s.expandLabel(SHTS, "key", nil, s.keyLen))
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, s.expandLabel(SHTS, "iv", nil, s.ivLen), nil
+}
+
+// expandLabel implements HKDF-Expand-Label of rfc 8446
+func (s cipherSuite) expandLabel(secret []byte, label string, context []byte, length int) []byte {
+
+	// HkdfLabel structure
+	var info bytes.Buffer
+	binary.Write(&info, binary.BigEndian, uint16(length))
+	info.WriteByte(byte(len("tls13 " + label)))
+	info.WriteString("tls13 " + label)
+	info.WriteByte(byte(len(context)))
+	info.Write(context)
+
+	// HKDF-Expand
+	var out, block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac := hmac.New(s.hash, secret)
+		mac.Write(block)
+		mac.Write(info.Bytes())
+		mac.Write([]byte{counter})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,74 @@
+package prove
+
+import (
+	"fmt"
+
+	glibg "client/tls-zkp/circuits/gadgets"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// PolicyCircuit proves all predicates of a policy in a single proof.
+// The oracle gadgets authenticate and decrypt the record chunks which
+// contain the predicate values, the predicate circuits constrain the
+// key and value in the decrypted chunks.
+// Every predicate is part of the circuit, disjunctions only reveal
+// that at least one predicate holds.
+type PolicyCircuit struct {
+	Predicates []glibg.Tls13OracleWrapper
+	// continuation of values straddling into the next record
+	Next   []glibg.Tls13OracleWrapper
+	Values []PredicateCircuit
+	// combinator of the record data public input
+	Disjunction bool
+	// sha256 hash of the canonical policy as two 128 bit halves
+	PolicyHash [2]frontend.Variable
+	// commitment of the policy hash and the constants of all predicates,
+	// binds the proof to the policy the verifier expects
+	PolicyCommitment frontend.Variable `gnark:",public"`
+}
+
+func (circuit *PolicyCircuit) Define(api frontend.API) error {
+	if len(circuit.Values) != len(circuit.Predicates) {
+		return fmt.Errorf("%d predicate circuits for %d oracle gadgets", len(circuit.Values), len(circuit.Predicates))
+	}
+	// constants compared against are those of the committed policy
+	err := assertCommitment(api, circuit.PolicyCommitment, circuit.PolicyHash, circuit.Disjunction, circuit.Values)
+	if err != nil {
+		return err
+	}
+	for i := range circuit.Next {
+		err := circuit.Next[i].Define(api)
+		if err != nil {
+			return err
+		}
+	}
+	holding := frontend.Variable(0)
+	for i := range circuit.Predicates {
+		err := circuit.Predicates[i].Define(api)
+		if err != nil {
+			return err
+		}
+
+		// chunks of the value across the record boundary
+		plain := circuit.Predicates[i].PlainChunks
+		if next := circuit.Values[i].Next; next >= 0 {
+			if next >= len(circuit.Next) {
+				return fmt.Errorf("predicate %d continues in unknown record gadget %d", i, next)
+			}
+			plain = append(append([]frontend.Variable{}, plain...), circuit.Next[next].PlainChunks...)
+		}
+		holds, err := circuit.Values[i].Holds(api, plain)
+		if err != nil {
+			return fmt.Errorf("predicate %d: %w", i, err)
+		}
+		if !circuit.Disjunction {
+			api.AssertIsEqual(holds, 1)
+		}
+		holding = api.Add(holding, holds)
+	}
+	if circuit.Disjunction {
+		api.AssertIsDifferent(holding, 0)
+	}
+	return nil
+}
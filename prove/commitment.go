@@ -0,0 +1,99 @@
+package prove
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	nativemimc "github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/mimc"
+)
+
+// The policy commitment public input is the mimc hash of the sha256 hash of the
+// canonical policy, the combinator and the constants of every predicate.
+// The circuit recomputes it from the constants it compares values against,
+// the verifier recomputes it from the policy it expects.
+// The layout of key and value is chosen by the prover and not committed,
+// the predicate circuit constrains it on the plaintext instead.
+
+// constants returns the policy constants of the predicate in commitment order,
+// variable length constants are prefixed by their length
+func (circuit *PredicateCircuit) constants() []frontend.Variable {
+	// freshness thresholds depend on the prover clock and are checked
+	// by the verifier on the public freshness input instead
+	threshold := circuit.Threshold
+	if circuit.Fresh {
+		threshold = 0
+	}
+	constants := []frontend.Variable{circuit.Operator, threshold, circuit.ThresholdUpper,
+		flag(circuit.Decimal), circuit.Scale, flag(circuit.Fresh), flag(circuit.Quoted)}
+	for _, list := range [][]frontend.Variable{circuit.ValueSet, circuit.Expected, circuit.ExpectedHash, circuit.Separators} {
+		constants = append(constants, len(list))
+		constants = append(constants, list...)
+	}
+	return constants
+}
+
+// flag encodes a boolean constant
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// commitmentInput returns the commitment preimage of the policy hash halves and predicates
+func commitmentInput(policyHash [2]frontend.Variable, disjunction bool, values []PredicateCircuit) []frontend.Variable {
+	input := []frontend.Variable{policyHash[0], policyHash[1], flag(disjunction), len(values)}
+	for i := range values {
+		input = append(input, values[i].constants()...)
+	}
+	return input
+}
+
+// assertCommitment constrains the policy commitment to the hash of the circuit constants
+func assertCommitment(api frontend.API, commitment frontend.Variable, policyHash [2]frontend.Variable, disjunction bool, values []PredicateCircuit) error {
+
+	// range check binds the halves to a 256 bit hash
+	for i := range policyHash {
+		api.ToBinary(policyHash[i], 128)
+	}
+
+	hasher, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+	hasher.Write(commitmentInput(policyHash, disjunction, values)...)
+	api.AssertIsEqual(hasher.Sum(), commitment)
+	return nil
+}
+
+// computeCommitment returns the policy commitment of an assignment
+func computeCommitment(policyHash [2]frontend.Variable, disjunction bool, values []PredicateCircuit) (*big.Int, error) {
+
+	hasher := nativemimc.NewMiMC()
+	for _, v := range commitmentInput(policyHash, disjunction, values) {
+		var e fr.Element
+		switch value := v.(type) {
+		case int:
+			if value < 0 {
+				return nil, fmt.Errorf("negative commitment input %d", value)
+			}
+			e.SetUint64(uint64(value))
+		case *big.Int:
+			if value.Sign() < 0 || value.Cmp(fr.Modulus()) >= 0 {
+				return nil, fmt.Errorf("commitment input %s out of field range", value)
+			}
+			e.SetBigInt(value)
+		default:
+			return nil, fmt.Errorf("unsupported commitment input %T", v)
+		}
+		b := e.Bytes()
+		_, err := hasher.Write(b[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return new(big.Int).SetBytes(hasher.Sum(nil)), nil
+}
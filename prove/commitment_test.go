@@ -0,0 +1,139 @@
+package prove
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// commitmentTestCircuit constrains the commitment of predicate constants given as witness
+type commitmentTestCircuit struct {
+	PolicyHash       [2]frontend.Variable
+	Values           []PredicateCircuit
+	Disjunction      bool
+	PolicyCommitment frontend.Variable `gnark:",public"`
+}
+
+func (circuit *commitmentTestCircuit) Define(api frontend.API) error {
+	return assertCommitment(api, circuit.PolicyCommitment, circuit.PolicyHash, circuit.Disjunction, circuit.Values)
+}
+
+// commitmentValues returns the constants of a threshold and a string predicate
+func commitmentValues(threshold int) []PredicateCircuit {
+	return []PredicateCircuit{
+		{Operator: circuitOperators["GT"], Freshness: 0, Threshold: threshold, ThresholdUpper: 0,
+			ValueSet: []frontend.Variable{}, Expected: []frontend.Variable{}, ExpectedHash: []frontend.Variable{}},
+		{Operator: circuitStringOperators["EQ"], Freshness: 0, Threshold: 0, ThresholdUpper: 0,
+			ValueSet: []frontend.Variable{}, Expected: []frontend.Variable{int('D'), int('E')}, ExpectedHash: []frontend.Variable{}, Quoted: true},
+	}
+}
+
+func TestCommitment(t *testing.T) {
+
+	hash := [2]frontend.Variable{new(big.Int).Lsh(big.NewInt(1), 127), big.NewInt(42)}
+	commitment, err := computeCommitment(hash, false, commitmentValues(30001))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		hash        [2]frontend.Variable
+		disjunction bool
+		values      []PredicateCircuit
+		valid       bool
+	}{
+		{"committed constants", hash, false, commitmentValues(30001), true},
+		{"other threshold", hash, false, commitmentValues(30000), false},
+		{"other combinator", hash, true, commitmentValues(30001), false},
+		{"other policy hash", [2]frontend.Variable{hash[0], big.NewInt(43)}, false, commitmentValues(30001), false},
+		{"predicate dropped", hash, false, commitmentValues(30001)[:1], false},
+		{"hash half out of range", [2]frontend.Variable{new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(42)}, false, commitmentValues(30001), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circuit := commitmentTestCircuit{Values: commitmentValues(0)[:len(tt.values)], Disjunction: tt.disjunction}
+			assignment := commitmentTestCircuit{
+				PolicyHash:       tt.hash,
+				Values:           tt.values,
+				Disjunction:      tt.disjunction,
+				PolicyCommitment: commitment,
+			}
+			err := test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField())
+			if tt.valid && err != nil {
+				t.Errorf("commitment rejected: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("commitment accepted")
+			}
+		})
+	}
+}
+
+func TestComputeCommitment(t *testing.T) {
+
+	hash := [2]frontend.Variable{big.NewInt(1), big.NewInt(2)}
+	a, err := computeCommitment(hash, false, commitmentValues(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := computeCommitment(hash, false, commitmentValues(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Cmp(b) != 0 {
+		t.Error("commitment is not deterministic")
+	}
+
+	// freshness thresholds are public inputs checked by the verifier
+	fresh := func(threshold int) []PredicateCircuit {
+		values := commitmentValues(threshold)
+		values[0].Fresh = true
+		return values
+	}
+	c, err := computeCommitment(hash, false, fresh(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := computeCommitment(hash, false, fresh(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Cmp(d) != 0 || c.Cmp(a) == 0 {
+		t.Error("commitment of freshness predicate depends on the threshold or ignores max_age")
+	}
+
+	// bool literals are unquoted
+	unquoted := commitmentValues(1)
+	unquoted[1].Quoted = false
+	e, err := computeCommitment(hash, false, unquoted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Cmp(a) == 0 {
+		t.Error("commitment ignores the value type of string predicates")
+	}
+
+	tests := []struct {
+		name   string
+		hash   [2]frontend.Variable
+		values []PredicateCircuit
+	}{
+		{"negative constant", hash, commitmentValues(-1)},
+		{"constant out of field", [2]frontend.Variable{fr.Modulus(), big.NewInt(2)}, commitmentValues(1)},
+		{"unsupported constant", [2]frontend.Variable{"1", big.NewInt(2)}, commitmentValues(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := computeCommitment(tt.hash, false, tt.values)
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package prove
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/sha2"
+	"github.com/consensys/gnark/std/math/uints"
+)
+
+// PredicateCircuit constrains the value of a single policy predicate in the
+// plaintext chunks decrypted by the oracle gadgets of its records.
+// Offsets are chunk level and continue across the record boundary
+// for values straddling into the next record, the chunk framing of
+// chunked http bodies is removed before the layout offsets apply.
+type PredicateCircuit struct {
+	// key preceding the value
+	Substring []frontend.Variable `gnark:",public"`
+	// threshold of max_age predicates, zero otherwise, the verifier checks it
+	// against its own clock as the prover chooses it at postprocessing time
+	Freshness frontend.Variable `gnark:",public"`
+	// policy constants at the policy precision
+	Threshold      frontend.Variable
+	ThresholdUpper frontend.Variable
+	ValueSet       []frontend.Variable
+	// expected bytes or sha256 hash of string constraints
+	Expected     []frontend.Variable
+	ExpectedHash []frontend.Variable
+	// bytes allowed at skipped value offsets, e.g. thousands separators
+	Separators []frontend.Variable
+	// comparison of the circuit operators
+	Operator int
+	// decimal values have at most one decimal point and Scale fractional digits
+	// including the implicit trailing zero digits
+	Decimal bool
+	Scale   int
+	// the threshold of max_age predicates is the public freshness threshold
+	Fresh bool
+	// chunk level ranges of the chunk framing inside the area of interest
+	Framing [][2]int
+	// layout of key and value in the plaintext without the framing
+	SubstringStart int
+	ValueStart     int
+	ValueEnd       int
+	// value offsets of separator bytes and implicit trailing zero digits
+	ValueSkip  []int
+	ValueShift int
+	// chunk level range of the bytes compared against the expected string
+	MatchStart int
+	MatchEnd   int
+	// string values end at the closing quote
+	Quoted bool
+	// index of the oracle gadget of the next record, -1 for values within one record
+	Next int
+}
+
+// bytes between the key and the value, e.g. the colon, whitespace and the opening quote
+var keyValueGap = []frontend.Variable{':', ' ', '\t', '\r', '\n', '"'}
+
+// bytes terminating unquoted bool values
+var literalEnd = []frontend.Variable{',', '}', ']', ' ', '\t', '\r', '\n'}
+
+// Holds constrains the key and the value layout and returns 1 if the value
+// satisfies the predicate, 0 otherwise. The result stays private so that
+// disjunctions do not reveal which predicate holds.
+// The layout is chosen by the prover, so the key, the bytes between key
+// and value and the value boundaries are constrained on the plaintext.
+func (circuit *PredicateCircuit) Holds(api frontend.API, plain []frontend.Variable) (frontend.Variable, error) {
+
+	// key and value may straddle chunks of a chunked body
+	plain, err := circuit.body(api, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	// area of interest includes the byte terminating the value
+	// and the byte preceding it
+	keyEnd := circuit.SubstringStart + len(circuit.Substring)
+	if circuit.SubstringStart < 0 || keyEnd > circuit.ValueStart || circuit.ValueStart < 1 ||
+		circuit.ValueStart > circuit.ValueEnd || circuit.ValueEnd >= len(plain) {
+		return nil, fmt.Errorf("predicate layout exceeds %d plaintext bytes", len(plain))
+	}
+
+	// the key identifies the value
+	for i := range circuit.Substring {
+		api.AssertIsEqual(plain[circuit.SubstringStart+i], circuit.Substring[i])
+	}
+
+	// the value follows the key, the gap holds no value bytes
+	for i := keyEnd; i < circuit.ValueStart; i++ {
+		assertOneOf(api, plain[i], keyValueGap)
+	}
+
+	return circuit.holds(api, plain)
+}
+
+// maximum number of hexadecimal digits of a chunk size
+const maxChunkSizeDigits = 8
+
+// body returns the plaintext without the chunk framing. A frame is a crlf,
+// the hexadecimal size of the following chunk and a crlf. Frames lie between
+// the first key byte and the byte terminating the value, the size of a frame
+// counts the bytes up to the next frame and, for the last frame, at least the
+// bytes up to the end of the area of interest, so that only framing of the
+// http body and no bytes of the body itself are removed.
+func (circuit *PredicateCircuit) body(api frontend.API, plain []frontend.Variable) ([]frontend.Variable, error) {
+
+	if len(circuit.Framing) == 0 {
+		return plain, nil
+	}
+
+	// at holds the body offset following each frame
+	body := make([]frontend.Variable, 0, len(plain))
+	sizes := make([]frontend.Variable, len(circuit.Framing))
+	at := make([]int, len(circuit.Framing))
+	last := 0
+	for k, frame := range circuit.Framing {
+		start, end := frame[0], frame[1]
+		if start < last || (k > 0 && start == last) || end-start < 5 || end-start > 4+maxChunkSizeDigits || end > len(plain) {
+			return nil, fmt.Errorf("invalid chunk frame [%d,%d)", start, end)
+		}
+		body = append(body, plain[last:start]...)
+		at[k] = len(body)
+		if at[k] <= circuit.SubstringStart || at[k] > circuit.ValueEnd {
+			return nil, fmt.Errorf("chunk frame [%d,%d) outside of the area of interest", start, end)
+		}
+
+		api.AssertIsEqual(plain[start], '\r')
+		api.AssertIsEqual(plain[start+1], '\n')
+		api.AssertIsEqual(plain[end-2], '\r')
+		api.AssertIsEqual(plain[end-1], '\n')
+		size := frontend.Variable(0)
+		for i := start + 2; i < end-2; i++ {
+			size = api.Add(api.Mul(size, 16), hexDigit(api, plain[i]))
+		}
+		// chunks of size zero end the body
+		api.AssertIsEqual(api.IsZero(size), 0)
+		sizes[k] = size
+		last = end
+	}
+	body = append(body, plain[last:]...)
+
+	for k := range sizes {
+		if k+1 < len(sizes) {
+			api.AssertIsEqual(sizes[k], at[k+1]-at[k])
+			continue
+		}
+		// the last chunk holds at least the rest of the area of interest
+		api.AssertIsEqual(isEqual(api, api.Cmp(sizes[k], circuit.ValueEnd+1-at[k]), -1), 0)
+	}
+	return body, nil
+}
+
+// holds returns 1 if the value satisfies the constraint of the operator, 0 otherwise
+func (circuit *PredicateCircuit) holds(api frontend.API, plain []frontend.Variable) (frontend.Variable, error) {
+
+	if circuit.Operator >= circuitStringOperators["EQ"] {
+		return circuit.holdsText(api, plain)
+	}
+
+	// freshness thresholds are revealed instead of committed
+	if circuit.Fresh {
+		api.AssertIsEqual(circuit.Freshness, circuit.Threshold)
+	} else {
+		api.AssertIsEqual(circuit.Freshness, 0)
+	}
+
+	// the value starts after and ends before a byte which is neither a digit
+	// nor a separator, so that no digits are cut off on either side
+	for _, i := range []int{circuit.ValueStart - 1, circuit.ValueEnd} {
+		api.AssertIsEqual(isDigit(api, plain[i]), 0)
+		api.AssertIsEqual(isOneOf(api, plain[i], circuit.Separators), 0)
+	}
+	value, err := circuit.value(api, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	switch circuit.Operator {
+	case circuitOperators["GT"]:
+		return isEqual(api, api.Cmp(value, circuit.Threshold), 1), nil
+	case circuitOperators["LT"]:
+		return isEqual(api, api.Cmp(value, circuit.Threshold), -1), nil
+	case circuitOperators["EQ"]:
+		return api.IsZero(api.Sub(value, circuit.Threshold)), nil
+	case circuitOperators["GE"]:
+		return api.Sub(1, isEqual(api, api.Cmp(value, circuit.Threshold), -1)), nil
+	case circuitOperators["LE"]:
+		return api.Sub(1, isEqual(api, api.Cmp(value, circuit.Threshold), 1)), nil
+	case circuitOperators["NE"]:
+		return api.Sub(1, api.IsZero(api.Sub(value, circuit.Threshold))), nil
+	case circuitOperators["BETWEEN"]:
+		lower := api.Sub(1, isEqual(api, api.Cmp(value, circuit.Threshold), -1))
+		upper := api.Sub(1, isEqual(api, api.Cmp(value, circuit.ThresholdUpper), 1))
+		return api.And(lower, upper), nil
+	case circuitOperators["IN"], circuitOperators["NOT_IN"]:
+		// the product of differences is zero for set members
+		product := frontend.Variable(1)
+		for _, member := range circuit.ValueSet {
+			product = api.Mul(product, api.Sub(value, member))
+		}
+		member := api.IsZero(product)
+		if circuit.Operator == circuitOperators["NOT_IN"] {
+			return api.Sub(1, member), nil
+		}
+		return member, nil
+	}
+	return nil, fmt.Errorf("unknown circuit operator %d", circuit.Operator)
+}
+
+// maximum number of decimal digits of a value including the shift,
+// larger integers may exceed the scalar field
+const maxValueDigits = 75
+
+// value returns the integer value of the digits at the policy precision,
+// skipped bytes must be separators of the policy and all other bytes digits
+func (circuit *PredicateCircuit) value(api frontend.API, plain []frontend.Variable) (frontend.Variable, error) {
+
+	skip := make(map[int]bool)
+	for _, i := range circuit.ValueSkip {
+		if i < 0 || i >= circuit.ValueEnd-circuit.ValueStart || skip[i] {
+			return nil, fmt.Errorf("invalid value skip offset %d", i)
+		}
+		skip[i] = true
+	}
+	digits := circuit.ValueEnd - circuit.ValueStart - len(skip)
+	if digits < 1 || circuit.ValueShift < 0 || digits+circuit.ValueShift > maxValueDigits {
+		return nil, fmt.Errorf("numeric value of %d digits and shift %d", digits, circuit.ValueShift)
+	}
+	if circuit.ValueShift > circuit.Scale || (!circuit.Decimal && circuit.ValueShift != 0) {
+		return nil, fmt.Errorf("value shift %d exceeds scale %d", circuit.ValueShift, circuit.Scale)
+	}
+
+	// points counts the decimal points read so far,
+	// fraction the digits following the decimal point
+	value := frontend.Variable(0)
+	points := frontend.Variable(0)
+	fraction := frontend.Variable(0)
+	for i := circuit.ValueStart; i < circuit.ValueEnd; i++ {
+		if skip[i-circuit.ValueStart] {
+			api.AssertIsEqual(isOneOf(api, plain[i], circuit.Separators), 1)
+			if circuit.Decimal {
+				// thousands separators precede the decimal point
+				point := isEqual(api, plain[i], '.')
+				api.AssertIsEqual(api.Mul(api.Sub(1, point), points), 0)
+				points = api.Add(points, point)
+			}
+			continue
+		}
+		api.AssertIsEqual(isDigit(api, plain[i]), 1)
+		value = api.Add(api.Mul(value, 10), api.Sub(plain[i], '0'))
+		fraction = api.Add(fraction, points)
+	}
+	if circuit.Decimal {
+		// at most one decimal point, the shift pads the fraction to the scale
+		api.AssertIsEqual(api.Mul(points, api.Sub(points, 1)), 0)
+		api.AssertIsEqual(api.Add(fraction, circuit.ValueShift), circuit.Scale)
+	}
+	for i := 0; i < circuit.ValueShift; i++ {
+		value = api.Mul(value, 10)
+	}
+	return value, nil
+}
+
+// holdsText compares the matched value bytes in clear or by sha256 hash
+func (circuit *PredicateCircuit) holdsText(api frontend.API, plain []frontend.Variable) (frontend.Variable, error) {
+
+	if circuit.MatchStart < circuit.ValueStart || circuit.MatchStart > circuit.MatchEnd || circuit.MatchEnd > circuit.ValueEnd {
+		return nil, fmt.Errorf("match range [%d,%d) outside of value [%d,%d)", circuit.MatchStart, circuit.MatchEnd, circuit.ValueStart, circuit.ValueEnd)
+	}
+
+	// the operator ties the match range to the value boundaries,
+	// prefixes to the value start and suffixes to the value end
+	anchorStart := circuit.Operator != circuitStringOperators["ENDS_WITH"]
+	anchorEnd := circuit.Operator != circuitStringOperators["STARTS_WITH"]
+	if (anchorStart && circuit.MatchStart != circuit.ValueStart) || (anchorEnd && circuit.MatchEnd != circuit.ValueEnd) {
+		return nil, fmt.Errorf("match range [%d,%d) not anchored to value [%d,%d)", circuit.MatchStart, circuit.MatchEnd, circuit.ValueStart, circuit.ValueEnd)
+	}
+
+	// quoted values span from the opening to the closing quote,
+	// unquoted literals end at a json delimiter or whitespace
+	if circuit.Quoted {
+		api.AssertIsEqual(plain[circuit.ValueStart-1], '"')
+		api.AssertIsEqual(plain[circuit.ValueEnd], '"')
+		for i := circuit.ValueStart; i < circuit.ValueEnd; i++ {
+			api.AssertIsEqual(isEqual(api, plain[i], '"'), 0)
+		}
+	} else {
+		api.AssertIsEqual(isEqual(api, plain[circuit.ValueStart-1], '"'), 0)
+		assertOneOf(api, plain[circuit.ValueEnd], literalEnd)
+	}
+	matched := plain[circuit.MatchStart:circuit.MatchEnd]
+
+	equal := frontend.Variable(1)
+	if len(circuit.ExpectedHash) > 0 {
+		uapi, err := uints.New[uints.U32](api)
+		if err != nil {
+			return nil, err
+		}
+		hasher, err := sha2.New(api)
+		if err != nil {
+			return nil, err
+		}
+		for i := range matched {
+			hasher.Write([]uints.U8{uapi.ByteValueOf(matched[i])})
+		}
+		hash := hasher.Sum()
+		if len(hash) != len(circuit.ExpectedHash) {
+			return nil, fmt.Errorf("expected hash of %d bytes", len(circuit.ExpectedHash))
+		}
+		for i := range hash {
+			equal = api.And(equal, api.IsZero(api.Sub(hash[i].Val, circuit.ExpectedHash[i])))
+		}
+	} else {
+		if len(matched) != len(circuit.Expected) {
+			return nil, fmt.Errorf("match range of %d bytes for expected string of %d bytes", len(matched), len(circuit.Expected))
+		}
+		for i := range matched {
+			equal = api.And(equal, api.IsZero(api.Sub(matched[i], circuit.Expected[i])))
+		}
+	}
+
+	if circuit.Operator == circuitStringOperators["NE"] {
+		return api.Sub(1, equal), nil
+	}
+	return equal, nil
+}
+
+// isDigit returns 1 if the byte is an ascii digit, 0 otherwise
+func isDigit(api frontend.API, b frontend.Variable) frontend.Variable {
+	// bytes below '0' wrap around to large field elements
+	return api.Sub(1, isEqual(api, api.Cmp(api.Sub(b, '0'), 9), 1))
+}
+
+// hexDigit constrains the byte to a hexadecimal digit and returns its value
+func hexDigit(api frontend.API, b frontend.Variable) frontend.Variable {
+	digit := isDigit(api, b)
+	lower := isOneOf(api, b, []frontend.Variable{'a', 'b', 'c', 'd', 'e', 'f'})
+	upper := isOneOf(api, b, []frontend.Variable{'A', 'B', 'C', 'D', 'E', 'F'})
+	api.AssertIsEqual(api.Add(digit, lower, upper), 1)
+	value := api.Mul(digit, api.Sub(b, '0'))
+	value = api.Add(value, api.Mul(lower, api.Sub(b, 'a'-10)))
+	return api.Add(value, api.Mul(upper, api.Sub(b, 'A'-10)))
+}
+
+// isOneOf returns 1 if the byte is a member of the set, 0 otherwise
+func isOneOf(api frontend.API, b frontend.Variable, set []frontend.Variable) frontend.Variable {
+	// the product of differences is zero for members
+	product := frontend.Variable(1)
+	for _, member := range set {
+		product = api.Mul(product, api.Sub(b, member))
+	}
+	return api.IsZero(product)
+}
+
+// assertOneOf constrains the byte to a member of the set
+func assertOneOf(api frontend.API, b frontend.Variable, set []frontend.Variable) {
+	product := frontend.Variable(1)
+	for _, member := range set {
+		product = api.Mul(product, api.Sub(b, member))
+	}
+	api.AssertIsEqual(product, 0)
+}
+
+// isEqual returns 1 if a equals the constant c, 0 otherwise
+func isEqual(api frontend.API, a frontend.Variable, c int) frontend.Variable {
+	return api.IsZero(api.Sub(a, c))
+}
@@ -0,0 +1,199 @@
+package prove
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// predicateTestCircuit constrains a predicate on plaintext chunks given as witness
+type predicateTestCircuit struct {
+	Plain []frontend.Variable
+	Value PredicateCircuit
+}
+
+func (circuit *predicateTestCircuit) Define(api frontend.API) error {
+	holds, err := circuit.Value.Holds(api, circuit.Plain)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(holds, 1)
+	return nil
+}
+
+// predicateParams returns the record data input of a value following the key in plain
+func predicateParams(plain string, key string, size int, params map[string]string) map[string]string {
+	start := strings.Index(plain, key) + len(key)
+	if plain[start] == '"' {
+		start++
+	}
+	p := map[string]string{
+		"substring":       key,
+		"substring_start": strconv.Itoa(strings.Index(plain, key)),
+		"value_start":     strconv.Itoa(start),
+		"value_end":       strconv.Itoa(start + size),
+		"size_value":      strconv.Itoa(size),
+	}
+	for k, v := range params {
+		p[k] = v
+	}
+	return p
+}
+
+func TestPredicateCircuit(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		plain  string
+		params map[string]string
+		holds  bool
+	}{
+		{"gt holds", `{"balance":38002,`, predicateParams(`{"balance":38002,`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001"}), true},
+		{"gt fails", `{"balance":38001,`, predicateParams(`{"balance":38001,`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001"}), false},
+		{"le holds on zero", `{"balance":0}`, predicateParams(`{"balance":0}`, `"balance":`, 1, map[string]string{
+			"value_constraint": "LE", "threshold": "0"}), true},
+		{"ne holds", `{"balance":7}`, predicateParams(`{"balance":7}`, `"balance":`, 1, map[string]string{
+			"value_constraint": "NE", "threshold": "8"}), true},
+		{"truncated value", `{"balance":38002,`, predicateParams(`{"balance":38002,`, `"balance":`, 4, map[string]string{
+			"value_constraint": "GT", "threshold": "100"}), false},
+		{"decimal with separators", `"amount":"1,234.5" `, predicateParams(`"amount":"1,234.5" `, `"amount":`, 7, map[string]string{
+			"value_type": "decimal", "value_constraint": "EQ", "threshold": "123450",
+			"value_skip": "1,5", "value_shift": "1", "separators": "2c2e", "scale": "2"}), true},
+		{"decimal point read as separator", `"amount":"1,234.5" `, predicateParams(`"amount":"1,234.5" `, `"amount":`, 7, map[string]string{
+			"value_type": "decimal", "value_constraint": "EQ", "threshold": "1234500",
+			"value_skip": "1,5", "value_shift": "2", "separators": "2c2e", "scale": "2"}), false},
+		{"thousands separator after decimal point", `"amount":"1.234,5" `, predicateParams(`"amount":"1.234,5" `, `"amount":`, 7, map[string]string{
+			"value_type": "decimal", "value_constraint": "EQ", "threshold": "123450",
+			"value_skip": "1,5", "value_shift": "0", "separators": "2c2e", "scale": "4"}), false},
+		{"shift beyond scale", `"amount":"1234.5" `, predicateParams(`"amount":"1234.5" `, `"amount":`, 6, map[string]string{
+			"value_type": "decimal", "value_constraint": "GT", "threshold": "1234000",
+			"value_skip": "4", "value_shift": "2", "separators": "2c2e", "scale": "2"}), false},
+		{"between holds", `"age":42,`, predicateParams(`"age":42,`, `"age":`, 2, map[string]string{
+			"value_constraint": "BETWEEN", "threshold": "18", "threshold_upper": "42"}), true},
+		{"between fails", `"age":43,`, predicateParams(`"age":43,`, `"age":`, 2, map[string]string{
+			"value_constraint": "BETWEEN", "threshold": "18", "threshold_upper": "42"}), false},
+		{"in holds", `"code":7,`, predicateParams(`"code":7,`, `"code":`, 1, map[string]string{
+			"value_constraint": "IN", "value_set": "3,7,9"}), true},
+		{"not in fails", `"code":7,`, predicateParams(`"code":7,`, `"code":`, 1, map[string]string{
+			"value_constraint": "NOT_IN", "value_set": "3,7,9"}), false},
+		{"value start moved into digits", `{"balance":938002,`, predicateParams(`{"balance":938002,`, `"balance":`, 5, map[string]string{
+			"value_constraint": "LT", "threshold": "40000", "value_start": "12", "value_end": "17"}), false},
+		{"digit skipped as separator", `{"balance":938002,`, predicateParams(`{"balance":938002,`, `"balance":`, 6, map[string]string{
+			"value_constraint": "LT", "threshold": "40000", "value_skip": "0", "separators": "2c"}), false},
+		{"value after unrelated bytes", `{"balance":9,"x":3,`, predicateParams(`{"balance":9,"x":3,`, `"balance":`, 1, map[string]string{
+			"value_constraint": "LT", "threshold": "5", "value_start": "17", "value_end": "18"}), false},
+		{"value straddling a chunk boundary", "{\"balance\":380\r\n3\r\n02}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "14-19"}), true},
+		{"key straddling a chunk boundary", "{\"bal\r\nC\r\nance\":38002}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "5-10"}), true},
+		{"value straddling two chunk boundaries", "{\"balance\":3\r\n2\r\n80\r\n3\r\n02}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "12-17,19-24"}), true},
+		{"chunk shorter than the area of interest", "{\"balance\":380\r\n2\r\n02}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "14-19"}), false},
+		{"chunk size differs from the next frame", "{\"balance\":3\r\n3\r\n80\r\n3\r\n02}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "12-17,19-24"}), false},
+		{"chunk size not hexadecimal", "{\"balance\":380\r\ng\r\n02}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "14-19"}), false},
+		{"chunk of size zero", "{\"balance\":380\r\n0\r\n02}", predicateParams(`{"balance":38002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "GT", "threshold": "38001", "framing": "14-19"}), false},
+		{"value bytes removed as framing", "{\"balance\":9\r\n5\r\n38002}", predicateParams(`{"balance":938002}`, `"balance":`, 5, map[string]string{
+			"value_constraint": "LT", "threshold": "40000", "value_start": "12", "value_end": "17", "framing": "12-17"}), false},
+		{"freshness holds", `"ts":1651057300,`, predicateParams(`"ts":1651057300,`, `"ts":`, 10, map[string]string{
+			"value_type": "timestamp", "value_constraint": "GE", "threshold": "1651057200", "max_age": "3600"}), true},
+		{"string eq holds", `"country":"DE",`, predicateParams(`"country":"DE",`, `"country":`, 2, map[string]string{
+			"value_type": "string", "value_constraint": "EQ", "expected": hex.EncodeToString([]byte("DE")),
+			"match_start": "11", "match_end": "13"}), true},
+		{"string eq on prefix fails", `"country":"DEU",`, predicateParams(`"country":"DEU",`, `"country":`, 2, map[string]string{
+			"value_type": "string", "value_constraint": "EQ", "expected": hex.EncodeToString([]byte("DE")),
+			"match_start": "11", "match_end": "13"}), false},
+		{"string eq on suffix fails", `"country":"XDE",`, predicateParams(`"country":"XDE",`, `"country":`, 3, map[string]string{
+			"value_type": "string", "value_constraint": "EQ", "expected": hex.EncodeToString([]byte("DE")),
+			"match_start": "12", "match_end": "14"}), false},
+		{"string eq on value moved past quote fails", `"country":"XDE",`, predicateParams(`"country":"XDE",`, `"country":`, 2, map[string]string{
+			"value_type": "string", "value_constraint": "EQ", "expected": hex.EncodeToString([]byte("DE")),
+			"value_start": "12", "value_end": "14", "match_start": "12", "match_end": "14"}), false},
+		{"string starts with mid value fails", `"iban":"GB89DE0",`, predicateParams(`"iban":"GB89DE0",`, `"iban":`, 7, map[string]string{
+			"value_type": "string", "value_constraint": "STARTS_WITH", "expected": hex.EncodeToString([]byte("DE")),
+			"match_start": "12", "match_end": "14"}), false},
+		{"string ends with", `"iban":"GB89DE",`, predicateParams(`"iban":"GB89DE",`, `"iban":`, 6, map[string]string{
+			"value_type": "string", "value_constraint": "ENDS_WITH", "expected": hex.EncodeToString([]byte("DE")),
+			"match_start": "12", "match_end": "14"}), true},
+		{"bool eq holds", `"active":true}`, predicateParams(`"active":true}`, `"active":`, 4, map[string]string{
+			"value_type": "bool", "value_constraint": "EQ", "expected": hex.EncodeToString([]byte("true")),
+			"match_start": "9", "match_end": "13"}), true},
+		{"bool eq on prefix fails", `"active":trueish}`, predicateParams(`"active":trueish}`, `"active":`, 4, map[string]string{
+			"value_type": "bool", "value_constraint": "EQ", "expected": hex.EncodeToString([]byte("true")),
+			"match_start": "9", "match_end": "13"}), false},
+		{"string starts with", `"iban":"DE89370",`, predicateParams(`"iban":"DE89370",`, `"iban":`, 7, map[string]string{
+			"value_type": "string", "value_constraint": "STARTS_WITH", "expected": hex.EncodeToString([]byte("DE")),
+			"match_start": "8", "match_end": "10"}), true},
+		{"string hash eq", `"name":"abc",`, predicateParams(`"name":"abc",`, `"name":`, 3, map[string]string{
+			"value_type": "string", "value_constraint": "EQ",
+			"expected_hash": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			"match_start":   "8", "match_end": "11"}), true},
+		{"string hash ne fails", `"name":"abc",`, predicateParams(`"name":"abc",`, `"name":`, 3, map[string]string{
+			"value_type": "string", "value_constraint": "NE",
+			"expected_hash": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			"match_start":   "8", "match_end": "11"}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, _, err := predicateCircuit(tt.params)
+			if err != nil {
+				t.Fatalf("predicateCircuit: %v", err)
+			}
+			circuit := predicateTestCircuit{Plain: make([]frontend.Variable, len(tt.plain)), Value: value}
+
+			assignValue, err := assignValue(tt.params)
+			if err != nil {
+				t.Fatalf("assignValue: %v", err)
+			}
+			assignment := predicateTestCircuit{Plain: make([]frontend.Variable, len(tt.plain)), Value: assignValue}
+			for i := range tt.plain {
+				assignment.Plain[i] = tt.plain[i]
+			}
+
+			err = test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField())
+			if tt.holds && err != nil {
+				t.Errorf("predicate does not hold: %v", err)
+			}
+			if !tt.holds && err == nil {
+				t.Error("predicate holds")
+			}
+		})
+	}
+}
+
+func TestPredicateCircuitParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+	}{
+		{"unknown operator", map[string]string{"value_constraint": "LIKE", "threshold": "1", "size_value": "1", "value_end": "1"}},
+		{"unknown value type", map[string]string{"value_type": "float", "value_constraint": "GT", "threshold": "1"}},
+		{"invalid threshold", map[string]string{"value_constraint": "GT", "threshold": "1.5"}},
+		{"invalid set member", map[string]string{"value_constraint": "IN", "value_set": "1,x"}},
+		{"value size mismatch", map[string]string{"value_constraint": "GT", "threshold": "1", "value_start": "0", "value_end": "3", "size_value": "2"}},
+		{"skip out of value", map[string]string{"value_constraint": "GT", "threshold": "1", "value_end": "2", "size_value": "2", "value_skip": "2"}},
+		{"invalid framing", map[string]string{"value_constraint": "GT", "threshold": "1", "value_end": "1", "size_value": "1", "framing": "5"}},
+		{"empty framing range", map[string]string{"value_constraint": "GT", "threshold": "1", "value_end": "1", "size_value": "1", "framing": "5-5"}},
+		{"expected length mismatch", map[string]string{"value_type": "string", "value_constraint": "EQ", "expected": "4445",
+			"match_start": "0", "match_end": "3", "value_end": "3", "size_value": "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := predicateCircuit(tt.params)
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -2,6 +2,10 @@ package prove
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,6 +22,30 @@ import (
 	"github.com/consensys/gnark/frontend"
 )
 
+// maps policy value constraints to the comparison
+// constraints implemented by the record data circuit
+var circuitOperators = map[string]int{
+	"GT": 0,
+	"LT": 1,
+	"EQ": 2,
+	"GE": 3,
+	"LE": 4,
+	"NE": 5,
+	// range and set membership constraints
+	"BETWEEN": 6,
+	"IN":      7,
+	"NOT_IN":  8,
+}
+
+// maps policy value constraints of string and bool values to the
+// constraints comparing value bytes against the expected string
+var circuitStringOperators = map[string]int{
+	"EQ":          9,
+	"NE":          10,
+	"STARTS_WITH": 11,
+	"ENDS_WITH":   12,
+}
+
 func CircuitAssign() (frontend.Circuit, frontend.Circuit, error) {
 
 	// read in data
@@ -26,18 +54,159 @@ func CircuitAssign() (frontend.Circuit, frontend.Circuit, error) {
 		log.Error().Msg("readOracleParams()")
 		return nil, nil, err
 	}
+	// the kdc circuit implements the sha256 key schedule with 16 byte keys
+	if suite := params["cipher_suite"]; suite != "" && suite != "TLS_AES_128_GCM_SHA256" {
+		err = fmt.Errorf("cipher suite %s not supported by the circuit", suite)
+		log.Error().Err(err).Msg("CircuitAssign()")
+		return nil, nil, err
+	}
+	predicates, err := readPredicateParams()
+	if err != nil {
+		log.Error().Msg("readPredicateParams()")
+		return nil, nil, err
+	}
+	tags, err := readRecordTagParams()
+	if err != nil {
+		log.Error().Msg("readRecordTagParams()")
+		return nil, nil, err
+	}
+
+	// canonical policy hash of the record data public input
+	hash, err := policyHash(predicates)
+	if err != nil {
+		log.Error().Err(err).Msg("policyHash(predicates)")
+		return nil, nil, err
+	}
+
+	// circuit definition from the public input
+	circuit, err := definePolicyCircuit(predicates)
+	if err != nil {
+		log.Error().Err(err).Msg("definePolicyCircuit(predicates)")
+		return nil, nil, err
+	}
+
+	// one oracle gadget per record and one predicate circuit per policy predicate
+	assignment := PolicyCircuit{
+		Predicates:  make([]glibg.Tls13OracleWrapper, len(predicates)),
+		Values:      make([]PredicateCircuit, len(predicates)),
+		Disjunction: circuit.Disjunction,
+		PolicyHash:  hash,
+	}
+	for i, predicate := range predicates {
+
+		// predicate params on top of session params
+		for k, v := range params {
+			predicate[k] = v
+		}
+
+		// authtag params of the record holding the value
+		tag, err := recordTag(tags, predicate["sequence"])
+		if err != nil {
+			log.Error().Err(err).Int("predicate", i).Msg("recordTag(tags, sequence)")
+			return nil, nil, err
+		}
+		for k, v := range tag {
+			predicate[k] = v
+		}
+
+		assignment.Values[i], err = assignValue(predicate)
+		if err != nil {
+			log.Error().Err(err).Int("predicate", i).Msg("assignValue(predicate)")
+			return nil, nil, err
+		}
+		assignment.Predicates[i] = assignRecord(predicate)
+
+		// chunks of a value straddling into the next record
+		if predicate["sequence_next"] != "" {
+			next, err := nextRecord(predicate, tags)
+			if err != nil {
+				log.Error().Err(err).Int("predicate", i).Msg("nextRecord(predicate, tags)")
+				return nil, nil, err
+			}
+			assignment.Values[i].Next = len(assignment.Next)
+			assignment.Next = append(assignment.Next, assignRecord(next))
+		}
+	}
+
+	// policy commitment public witness
+	assignment.PolicyCommitment, err = computeCommitment(hash, assignment.Disjunction, assignment.Values)
+	if err != nil {
+		log.Error().Err(err).Msg("computeCommitment(hash, values)")
+		return nil, nil, err
+	}
+
+	return circuit, &assignment, nil
+}
+
+// nextRecord returns the record params of the continuation of a straddling value
+func nextRecord(params map[string]string, tags map[string]map[string]string) (map[string]string, error) {
+	tag, err := recordTag(tags, params["sequence_next"])
+	if err != nil {
+		return nil, err
+	}
+	next := make(map[string]string)
+	for k, v := range params {
+		next[k] = v
+	}
+	for k, v := range tag {
+		next[k] = v
+	}
+	next["chunk_index"] = params["chunk_index_next"]
+	next["cipher_chunks"] = params["cipher_chunks_next"]
+	next["plain_chunks"] = params["plain_chunks_next"]
+	return next, nil
+}
+
+// assignValue returns the witness assignment of the predicate circuit of a single policy predicate
+func assignValue(params map[string]string) (PredicateCircuit, error) {
+
+	// policy values, fail before any witness is computed
+	assignment, pp, err := predicateCircuit(params)
+	if err != nil {
+		log.Error().Err(err).Msg("predicateCircuit(params)")
+		return PredicateCircuit{}, err
+	}
+
+	substringAssign := glibg.StrToIntSlice(params["substring"], false)
+	for i := range assignment.Substring {
+		assignment.Substring[i] = substringAssign[i]
+	}
+	assignment.Threshold = pp.threshold
+	assignment.Freshness = 0
+	if pp.fresh {
+		assignment.Freshness = pp.threshold
+	}
+	assignment.ThresholdUpper = pp.thresholdUpper
+	for i := range assignment.ValueSet {
+		assignment.ValueSet[i] = pp.set[i]
+	}
+	for i := range assignment.Expected {
+		assignment.Expected[i] = pp.expected[i]
+	}
+	for i := range assignment.ExpectedHash {
+		assignment.ExpectedHash[i] = pp.expectedHash[i]
+	}
+	for i := range assignment.Separators {
+		assignment.Separators[i] = pp.separators[i]
+	}
+	return assignment, nil
+}
+
+// assignRecord returns the witness assignment of the oracle gadget decrypting the record chunks,
+// key and value are constrained by the predicate circuit
+func assignRecord(params map[string]string) glibg.Tls13OracleWrapper {
+
+	// record nonce, iv xor sequence number, defaults to the iv of the first record
+	nonce := params["nonce"]
+	if nonce == "" {
+		nonce = params["ivSapp"]
+	}
 
 	// further preprocessing
 	zeros := "00000000000000000000000000000000"
-	ivCounter := addCounter(params["ivSapp"])
+	ivCounter := addCounter(nonce)
 	newdHSin, dHSinByteLen := padDHSin(params["dHSin"])
 	chunkIndex, _ := strconv.Atoi(params["chunk_index"])
-	substringStart, _ := strconv.Atoi(params["substring_start"])
-	substringEnd, _ := strconv.Atoi(params["substring_end"])
-	valueStart, _ := strconv.Atoi(params["value_start"])
-	valueEnd, _ := strconv.Atoi(params["value_end"])
-	// !!! policy value !!!
-	threshold := 38001
 
 	// kdc to bytes
 	byteSlice, _ := hex.DecodeString(params["intermediateHashHSopad"])
@@ -58,13 +227,12 @@ func CircuitAssign() (frontend.Circuit, frontend.Circuit, error) {
 	byteSlice, _ = hex.DecodeString(params["ECBK"])
 	ecbkByteLen := len(byteSlice)
 	// record to bytes
-	byteSlice, _ = hex.DecodeString(params["ivSapp"])
+	byteSlice, _ = hex.DecodeString(nonce)
 	ivByteLen := len(byteSlice)
 	byteSlice, _ = hex.DecodeString(params["cipher_chunks"])
 	chipherChunksByteLen := len(byteSlice)
 	byteSlice, _ = hex.DecodeString(params["plain_chunks"])
 	plainChunksByteLen := len(byteSlice)
-	substringByteLen := len(params["substring"])
 
 	// witness definition kdc
 	intermediateHashHSopadAssign := glibg.StrToIntSlice(params["intermediateHashHSopad"], true)
@@ -79,37 +247,27 @@ func CircuitAssign() (frontend.Circuit, frontend.Circuit, error) {
 	ecb0Assign := glibg.StrToIntSlice(params["ECB0"], true)
 	ecbkAssign := glibg.StrToIntSlice(params["ECBK"], true)
 	// witness definition record
-	ivAssign := glibg.StrToIntSlice(params["ivSapp"], true)
+	ivAssign := glibg.StrToIntSlice(nonce, true)
 	chipherChunksAssign := glibg.StrToIntSlice(params["cipher_chunks"], true)
 	plainChunksAssign := glibg.StrToIntSlice(params["plain_chunks"], true)
-	substringAssign := glibg.StrToIntSlice(params["substring"], false)
 
 	// witness values preparation
-	assignment := glibg.Tls13OracleWrapper{
-		// kdc params
-		IntermediateHashHSopad: [32]frontend.Variable{},
-		DHSin:                  [64]frontend.Variable{},
-		MSin:                   [32]frontend.Variable{},
-		SATSin:                 [32]frontend.Variable{},
-		TkSAPPin:               [32]frontend.Variable{},
-		// TkCommit:               [32]frontend.Variable{},
-		// authtag params
-		IvCounter: [16]frontend.Variable{},
-		Zeros:     [16]frontend.Variable{},
-		ECB0:      [16]frontend.Variable{},
-		ECBK:      [16]frontend.Variable{},
-		// record pararms
-		PlainChunks:    make([]frontend.Variable, plainChunksByteLen),
-		Iv:             [12]frontend.Variable{},
-		CipherChunks:   make([]frontend.Variable, chipherChunksByteLen),
-		ChunkIndex:     chunkIndex,
-		Substring:      make([]frontend.Variable, substringByteLen),
-		SubstringStart: substringStart,
-		SubstringEnd:   substringEnd,
-		ValueStart:     valueStart,
-		ValueEnd:       valueEnd,
-		Threshold:      threshold,
-	}
+	assignment := recordCircuit(chipherChunksByteLen)
+	// kdc params
+	assignment.IntermediateHashHSopad = [32]frontend.Variable{}
+	assignment.DHSin = [64]frontend.Variable{}
+	assignment.MSin = [32]frontend.Variable{}
+	assignment.SATSin = [32]frontend.Variable{}
+	assignment.TkSAPPin = [32]frontend.Variable{}
+	// authtag params
+	assignment.IvCounter = [16]frontend.Variable{}
+	assignment.Zeros = [16]frontend.Variable{}
+	assignment.ECB0 = [16]frontend.Variable{}
+	assignment.ECBK = [16]frontend.Variable{}
+	// record pararms
+	assignment.Iv = [12]frontend.Variable{}
+	assignment.ChunkIndex = chunkIndex
+	assignment.Threshold = 0
 
 	// kdc assign
 	for i := 0; i < intermediateHashHSopadByteLen; i++ {
@@ -150,20 +308,6 @@ func CircuitAssign() (frontend.Circuit, frontend.Circuit, error) {
 	for i := 0; i < chipherChunksByteLen; i++ {
 		assignment.CipherChunks[i] = chipherChunksAssign[i]
 	}
-	for i := 0; i < substringByteLen; i++ {
-		assignment.Substring[i] = substringAssign[i]
-	}
-
-	// var circuit kdcServerKey
-	circuit := glibg.Tls13OracleWrapper{
-		PlainChunks:    make([]frontend.Variable, plainChunksByteLen),
-		CipherChunks:   make([]frontend.Variable, chipherChunksByteLen),
-		Substring:      make([]frontend.Variable, substringByteLen),
-		SubstringStart: substringStart,
-		SubstringEnd:   substringEnd,
-		ValueStart:     valueStart,
-		ValueEnd:       valueEnd,
-	}
 
 	log.Trace().Msgf("intermediateHashHSopadAssign: %v", intermediateHashHSopadAssign)
 	log.Trace().Msgf("dHSinAssign: %v", dHSinAssign)
@@ -176,7 +320,177 @@ func CircuitAssign() (frontend.Circuit, frontend.Circuit, error) {
 	log.Trace().Msgf("chipherChunksAssign: %v", chipherChunksAssign)
 	log.Trace().Msgf("ivAssign: %v", ivAssign)
 
-	return &circuit, &assignment, err
+	return assignment
+}
+
+// policyHash splits the policy commitment of the record data public input
+// into two 128 bit halves, all predicates must commit to the same policy
+func policyHash(predicates []map[string]string) ([2]frontend.Variable, error) {
+
+	commitment := predicates[0]["policy_commitment"]
+	for _, predicate := range predicates {
+		if predicate["policy_commitment"] != commitment {
+			return [2]frontend.Variable{}, errors.New("predicates commit to different policies")
+		}
+	}
+
+	hash, err := hex.DecodeString(commitment)
+	if err != nil || len(hash) != 32 {
+		return [2]frontend.Variable{}, fmt.Errorf("invalid policy commitment %q", commitment)
+	}
+	return [2]frontend.Variable{
+		new(big.Int).SetBytes(hash[:16]),
+		new(big.Int).SetBytes(hash[16:]),
+	}, nil
+}
+
+// policy dependent circuit parameters of the record data proof
+type policyParams struct {
+	operator int
+	// lower bound for BETWEEN constraints
+	threshold int
+	// inclusive upper bound of BETWEEN constraints
+	thresholdUpper int
+	// set members of IN and NOT_IN constraints
+	set []int
+	// expected bytes or sha256 hash of string constraints
+	expected     []int
+	expectedHash []int
+	// chunk level range of the bytes compared against the expected string
+	matchStart int
+	matchEnd   int
+	skip       []int
+	shift      int
+	// chunk level ranges of the chunk framing
+	framing [][2]int
+	// bytes allowed at skipped value offsets
+	separators []int
+	// fractional digits of decimal values
+	scale int
+	// threshold is the freshness threshold of a max_age predicate
+	fresh bool
+}
+
+// policyConstraint returns the circuit parameters of the policy
+// stored with the record data public input
+func policyConstraint(params map[string]string) (policyParams, error) {
+
+	// numeric values are compared as integer at the policy precision,
+	// string values byte wise against the expected string
+	var operator int
+	var ok, text bool
+	switch params["value_type"] {
+	case "", "int", "decimal", "date", "time", "timestamp":
+		operator, ok = circuitOperators[params["value_constraint"]]
+	case "string", "bool":
+		operator, ok = circuitStringOperators[params["value_constraint"]]
+		text = true
+	default:
+		return policyParams{}, fmt.Errorf("no circuit comparison for policy value_type %q", params["value_type"])
+	}
+
+	// operator must map to a constraint of the circuit
+	if !ok {
+		return policyParams{}, fmt.Errorf("no circuit constraint for policy value_constraint %q", params["value_constraint"])
+	}
+
+	// constraint values depend on the operator
+	var threshold, thresholdUpper, matchStart, matchEnd int
+	set := []int{}
+	expected := []int{}
+	expectedHash := []int{}
+	var err error
+	switch {
+	case text:
+		if params["expected_hash"] != "" {
+			hash, err := hex.DecodeString(params["expected_hash"])
+			if err != nil || len(hash) != 32 {
+				return policyParams{}, fmt.Errorf("invalid policy expected_hash %q", params["expected_hash"])
+			}
+			expectedHash = glibg.StrToIntSlice(params["expected_hash"], true)
+		} else {
+			expected = glibg.StrToIntSlice(params["expected"], true)
+		}
+		matchStart, _ = strconv.Atoi(params["match_start"])
+		matchEnd, _ = strconv.Atoi(params["match_end"])
+		if params["expected_hash"] == "" && matchEnd-matchStart != len(expected) {
+			return policyParams{}, fmt.Errorf("match indices [%d,%d) do not match expected string length %d", matchStart, matchEnd, len(expected))
+		}
+	case params["value_constraint"] == "IN" || params["value_constraint"] == "NOT_IN":
+		for _, member := range strings.Split(params["value_set"], ",") {
+			m, err := strconv.Atoi(member)
+			if err != nil {
+				return policyParams{}, fmt.Errorf("invalid policy value_set member %q: %w", member, err)
+			}
+			set = append(set, m)
+		}
+	case params["value_constraint"] == "BETWEEN":
+		thresholdUpper, err = strconv.Atoi(params["threshold_upper"])
+		if err != nil {
+			return policyParams{}, fmt.Errorf("invalid policy upper_value %q: %w", params["threshold_upper"], err)
+		}
+		fallthrough
+	default:
+		threshold, err = strconv.Atoi(params["threshold"])
+		if err != nil {
+			return policyParams{}, fmt.Errorf("invalid policy threshold_value %q: %w", params["threshold"], err)
+		}
+	}
+
+	// value indices must cover exactly the located value
+	valueLength, _ := strconv.Atoi(params["size_value"])
+	valueStart, _ := strconv.Atoi(params["value_start"])
+	valueEnd, _ := strconv.Atoi(params["value_end"])
+	if valueEnd-valueStart != valueLength {
+		return policyParams{}, fmt.Errorf("value indices [%d,%d) do not match value size %d", valueStart, valueEnd, valueLength)
+	}
+
+	// separator bytes skipped by the circuit
+	skip := []int{}
+	if params["value_skip"] != "" {
+		for _, idx := range strings.Split(params["value_skip"], ",") {
+			i, err := strconv.Atoi(idx)
+			if err != nil || i < 0 || i >= valueLength {
+				return policyParams{}, fmt.Errorf("invalid value_skip index %q", idx)
+			}
+			skip = append(skip, i)
+		}
+	}
+	shift, _ := strconv.Atoi(params["value_shift"])
+
+	// chunk framing removed by the circuit
+	framing := [][2]int{}
+	if params["framing"] != "" {
+		for _, frame := range strings.Split(params["framing"], ",") {
+			var start, end int
+			_, err := fmt.Sscanf(frame, "%d-%d", &start, &end)
+			if err != nil || start < 0 || end <= start {
+				return policyParams{}, fmt.Errorf("invalid framing range %q", frame)
+			}
+			framing = append(framing, [2]int{start, end})
+		}
+	}
+	scale, _ := strconv.Atoi(params["scale"])
+	if _, err := hex.DecodeString(params["separators"]); err != nil {
+		return policyParams{}, fmt.Errorf("invalid separators %q", params["separators"])
+	}
+
+	return policyParams{
+		operator:       operator,
+		threshold:      threshold,
+		thresholdUpper: thresholdUpper,
+		set:            set,
+		expected:       expected,
+		expectedHash:   expectedHash,
+		matchStart:     matchStart,
+		matchEnd:       matchEnd,
+		skip:           skip,
+		shift:          shift,
+		framing:        framing,
+		separators:     glibg.StrToIntSlice(params["separators"], true),
+		scale:          scale,
+		fresh:          params["max_age"] != "",
+	}, nil
 }
 
 func readOracleParams() (map[string]string, error) {
@@ -208,43 +522,86 @@ func readOracleParams() (map[string]string, error) {
 		finalMap[k] = v
 	}
 
+	return finalMap, nil
+}
+
+// readRecordTagParams returns the authtag public input keyed by record sequence number
+func readRecordTagParams() (map[string]map[string]string, error) {
+
 	// read in authtag params
-	tag_pub, err := u.ReadMM("./local_storage/recordtag_public_input.json")
+	tag_pub, err := u.ReadMMKeyed("./local_storage/recordtag_public_input.json")
 	if err != nil {
-		log.Error().Msg("u.ReadMM")
+		log.Error().Msg("u.ReadMMKeyed")
 		return nil, err
 	}
+	return tag_pub, nil
+}
 
-	// copy
-	for k, v := range tag_pub {
-		finalMap[k] = v
+// recordTag returns the authtag params of the record with the given sequence number,
+// input without sequence numbers holds a single record
+func recordTag(tags map[string]map[string]string, sequence string) (map[string]string, error) {
+	if sequence == "" && len(tags) == 1 {
+		for _, tag := range tags {
+			return tag, nil
+		}
 	}
+	tag, ok := tags[sequence]
+	if !ok {
+		return nil, fmt.Errorf("no authtag input for record %q", sequence)
+	}
+	return tag, nil
+}
+
+// readPredicateParams returns the public and private record data input
+// of every proven policy predicate ordered by predicate index
+func readPredicateParams() ([]map[string]string, error) {
 
 	// read in record publ params
-	record_pub, err := u.ReadM("./local_storage/recorddata_public_input.json")
+	record_pub, err := u.ReadMMKeyed("./local_storage/recorddata_public_input.json")
 	if err != nil {
-		log.Error().Msg("u.ReadM")
+		log.Error().Msg("u.ReadMMKeyed")
 		return nil, err
 	}
 
-	// copy
-	for k, v := range record_pub {
-		finalMap[k] = v
-	}
-
 	// read in record private params
-	record_priv, err := u.ReadM("./local_storage/recorddata_private_input.json")
+	record_priv, err := u.ReadMMKeyed("./local_storage/recorddata_private_input.json")
 	if err != nil {
-		log.Error().Msg("u.ReadM")
+		log.Error().Msg("u.ReadMMKeyed")
 		return nil, err
 	}
 
+	return mergePredicates(record_pub, record_priv)
+}
+
+// mergePredicates merges maps keyed by predicate index into a slice ordered by index
+func mergePredicates(maps ...map[string]map[string]string) ([]map[string]string, error) {
+
+	// predicate indices
+	indices := []int{}
+	for k := range maps[0] {
+		i, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid predicate index %q", k)
+		}
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
 	// copy
-	for k, v := range record_priv {
-		finalMap[k] = v
+	predicates := make([]map[string]string, len(indices))
+	for n, i := range indices {
+		predicates[n] = make(map[string]string)
+		for _, m := range maps {
+			for k, v := range m[strconv.Itoa(i)] {
+				predicates[n][k] = v
+			}
+		}
 	}
 
-	return finalMap, nil
+	if len(predicates) == 0 {
+		return nil, errors.New("no policy predicate in record data input")
+	}
+	return predicates, nil
 }
 
 func addCounter(iv string) string {
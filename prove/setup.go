@@ -4,6 +4,7 @@ import (
 	glg "client/tls-zkp/circuits/gadgets"
 	u "client/utils"
 	"encoding/hex"
+	"fmt"
 	"strconv"
 
 	"github.com/rs/zerolog/log"
@@ -24,53 +25,117 @@ import (
 func GetCircuit() (frontend.Circuit, error) {
 
 	// read data which defines circuit size
-	params, err := readCircuitParams()
+	predicates, err := readCircuitParams()
 	if err != nil {
 		log.Error().Err(err).Msg("readCircuitParams()")
 		return nil, err
 	}
 
-	// cipher chunks bytes
-	cipherChunksBytes, _ := hex.DecodeString(params["cipher_chunks"])
-	cipherChunksByteLen := len(cipherChunksBytes)
+	circuit, err := definePolicyCircuit(predicates)
+	if err != nil {
+		log.Error().Err(err).Msg("definePolicyCircuit(predicates)")
+		return nil, err
+	}
+	return circuit, nil
+}
+
+// definePolicyCircuit returns the circuit definition of the record data public input,
+// one oracle gadget per record and one predicate circuit per policy predicate
+func definePolicyCircuit(predicates []map[string]string) (*PolicyCircuit, error) {
+
+	circuit := PolicyCircuit{
+		Predicates:  make([]glg.Tls13OracleWrapper, len(predicates)),
+		Values:      make([]PredicateCircuit, len(predicates)),
+		Disjunction: predicates[0]["combinator"] == "OR",
+	}
+	for i, params := range predicates {
+
+		// all predicates are combined by the same combinator
+		if params["combinator"] != predicates[0]["combinator"] {
+			return nil, fmt.Errorf("predicate %d: combinator %q differs from %q", i, params["combinator"], predicates[0]["combinator"])
+		}
+
+		// cipher chunks bytes
+		cipherChunksBytes, _ := hex.DecodeString(params["cipher_chunks"])
+		circuit.Predicates[i] = recordCircuit(len(cipherChunksBytes))
+
+		// comparison constraint selected by the policy
+		var err error
+		circuit.Values[i], _, err = predicateCircuit(params)
+		if err != nil {
+			return nil, fmt.Errorf("predicate %d: %w", i, err)
+		}
+
+		// chunks of a value straddling into the next record
+		if params["sequence_next"] != "" {
+			cipherChunksNextBytes, _ := hex.DecodeString(params["cipher_chunks_next"])
+			circuit.Values[i].Next = len(circuit.Next)
+			circuit.Next = append(circuit.Next, recordCircuit(len(cipherChunksNextBytes)))
+		}
+	}
+
+	return &circuit, nil
+}
+
+// recordCircuit returns the oracle gadget definition of the record chunks,
+// the key and value ranges of the gadget are empty as key and value
+// are constrained by the predicate circuit on the decrypted chunks
+func recordCircuit(cipherChunksByteLen int) glg.Tls13OracleWrapper {
+	return glg.Tls13OracleWrapper{
+		PlainChunks:  make([]frontend.Variable, cipherChunksByteLen),
+		CipherChunks: make([]frontend.Variable, cipherChunksByteLen),
+		Substring:    make([]frontend.Variable, 0),
+	}
+}
+
+// predicateCircuit returns the predicate circuit definition and
+// the policy values of a single policy predicate
+func predicateCircuit(params map[string]string) (PredicateCircuit, policyParams, error) {
+
+	pp, err := policyConstraint(params)
+	if err != nil {
+		return PredicateCircuit{}, policyParams{}, err
+	}
+
 	// convert str to int
 	sss, _ := strconv.Atoi(params["substring_start"])
-	sse, _ := strconv.Atoi(params["substring_end"])
 	vs, _ := strconv.Atoi(params["value_start"])
 	ve, _ := strconv.Atoi(params["value_end"])
 
-	// var circuit kdcServerKey
-	circuit := glg.Tls13OracleWrapper{
-		PlainChunks:    make([]frontend.Variable, cipherChunksByteLen),
-		CipherChunks:   make([]frontend.Variable, cipherChunksByteLen),
+	circuit := PredicateCircuit{
 		Substring:      make([]frontend.Variable, len(params["substring"])),
+		ValueSet:       make([]frontend.Variable, len(pp.set)),
+		Expected:       make([]frontend.Variable, len(pp.expected)),
+		ExpectedHash:   make([]frontend.Variable, len(pp.expectedHash)),
+		Separators:     make([]frontend.Variable, len(pp.separators)),
+		Operator:       pp.operator,
+		Decimal:        params["value_type"] == "decimal",
+		Scale:          pp.scale,
+		Fresh:          pp.fresh,
+		Framing:        pp.framing,
 		SubstringStart: sss,
-		SubstringEnd:   sse,
 		ValueStart:     vs,
 		ValueEnd:       ve,
+		ValueSkip:      pp.skip,
+		ValueShift:     pp.shift,
+		MatchStart:     pp.matchStart,
+		MatchEnd:       pp.matchEnd,
+		Quoted:         params["value_type"] == "string",
+		Next:           -1,
 	}
-
-	return &circuit, nil
+	return circuit, pp, nil
 }
 
-func readCircuitParams() (map[string]string, error) {
-
-	// to be returned
-	finalMap := make(map[string]string)
+func readCircuitParams() ([]map[string]string, error) {
 
 	// read in record publ params
-	record_pub, err := u.ReadM("./local_storage/recorddata_public_input.json")
+	record_pub, err := u.ReadMMKeyed("./local_storage/recorddata_public_input.json")
 	if err != nil {
-		log.Error().Msg("u.ReadM")
+		log.Error().Msg("u.ReadMMKeyed")
 		return nil, err
 	}
 
-	// copy
-	for k, v := range record_pub {
-		finalMap[k] = v
-	}
-
-	return finalMap, nil
+	return mergePredicates(record_pub)
 }
 
 func CompileCircuit(backend string, circuit frontend.Circuit) (constraint.ConstraintSystem, error) {
@@ -0,0 +1,23 @@
+package request
+
+import (
+	tls "client/tls-fork"
+	"errors"
+	"fmt"
+)
+
+// loadClientCertificate loads the pem encoded client certificate and key,
+// nil if no client certificate is configured
+func (r *RequestTLS) loadClientCertificate() (*tls.Certificate, error) {
+	if r.ClientCertificate == "" && r.ClientKey == "" {
+		return nil, nil
+	}
+	if r.ClientCertificate == "" || r.ClientKey == "" {
+		return nil, errors.New("client certificate and client key must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(r.ClientCertificate, r.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("client certificate: %w", err)
+	}
+	return &cert, nil
+}
@@ -0,0 +1,45 @@
+package request
+
+import (
+	tls "client/tls-fork"
+	"fmt"
+	"strings"
+)
+
+// key exchange groups selectable by name
+var curves = map[string]tls.CurveID{
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"X25519": tls.X25519,
+}
+
+// default group
+var DefaultCurves = []string{"P256"}
+
+// SplitNames splits a comma separated list of names
+func SplitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// curveIDs maps group names in order of preference to their ids
+func curveIDs(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		names = DefaultCurves
+	}
+	ids := make([]tls.CurveID, len(names))
+	for i, name := range names {
+		id, ok := curves[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
@@ -0,0 +1,39 @@
+package request
+
+import (
+	tls "client/tls-fork"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCurveIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		ids   []tls.CurveID
+		err   string
+	}{
+		{"default", nil, []tls.CurveID{tls.CurveP256}, ""},
+		{"preference order", []string{"x25519", "P256"}, []tls.CurveID{tls.X25519, tls.CurveP256}, ""},
+		{"unknown", []string{"P521"}, nil, "unsupported curve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := curveIDs(tt.names)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("ids %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Exchange is a request-response roundtrip on the attested tls session,
+// policies select the exchange holding their value by index
+type Exchange struct {
+	// server path including query
+	Path        string
+	Method      string
+	Body        []byte
+	ContentType string
+	// headers on top of the request headers, values may
+	// reference environment variables as ${NAME}
+	Headers map[string]string
+}
+
+// sentExchange is an exchange as sent on the attested session
+type sentExchange struct {
+	Exchange
+	// serialized http request
+	request []byte
+	// sequence number of the first client record of the request
+	sequence int
+	// response status code
+	status int
+	// response is a redirect followed on the same session
+	redirected bool
+}
+
+// exchanges returns the first exchange described by the request
+// fields followed by the additional exchanges of the session
+func (r *RequestTLS) exchanges() []Exchange {
+	path := r.ServerPath
+	if r.UrlPrivateParts != "" {
+		path += r.UrlPrivateParts
+	}
+	first := Exchange{
+		Path:        path,
+		Method:      r.Method,
+		Body:        r.Body,
+		ContentType: r.ContentType,
+	}
+	return append([]Exchange{first}, r.Exchanges...)
+}
+
+// checkMethod rejects unsupported methods and bodies on methods without body
+func (ex Exchange) checkMethod() error {
+	switch ex.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return fmt.Errorf("unsupported http method %q", ex.Method)
+	}
+	if len(ex.Body) > 0 && !bodyMethods[ex.Method] {
+		return fmt.Errorf("http method %s does not take a request body", ex.Method)
+	}
+	return nil
+}
+
+// roundTrip sends the exchange request and reads the full response,
+// cookies the jar holds for the request url are sent along and
+// cookies set by the response are stored in the jar
+func (r *RequestTLS) roundTrip(ctx context.Context, bufr *bufio.Reader, bufw *bufio.Writer, ex Exchange, jar http.CookieJar) ([]byte, *http.Response, error) {
+
+	// build request, bytes.Reader bodies set the content length
+	serverURL := "https://" + r.serverHost() + ex.Path
+	request, err := http.NewRequest(ex.Method, serverURL, bytes.NewReader(ex.Body))
+	if err != nil {
+		log.Error().Err(err).Msg("http.NewRequest()")
+		return nil, nil, err
+	}
+	request.Close = false
+
+	// request headers, identity encoding keeps the response
+	// body byte aligned with the record plaintext
+	request.Header.Set("Accept-Encoding", "identity")
+	if ex.ContentType != "" {
+		request.Header.Set("Content-Type", ex.ContentType)
+	}
+	if r.AccessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+r.AccessToken)
+	}
+	for _, cookie := range jar.Cookies(request.URL) {
+		request.AddCookie(cookie)
+	}
+	err = setHeaders(request, r.Headers)
+	if err != nil {
+		log.Error().Err(err).Msg("setHeaders(request, r.Headers)")
+		return nil, nil, err
+	}
+	err = setHeaders(request, ex.Headers)
+	if err != nil {
+		log.Error().Err(err).Msg("setHeaders(request, ex.Headers)")
+		return nil, nil, err
+	}
+
+	// serialize request once, so that the recorded client records
+	// hold exactly the stored request bytes
+	var requestBytes bytes.Buffer
+	err = request.Write(&requestBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("request.Write(&requestBytes)")
+		return nil, nil, err
+	}
+
+	// write request to connection buffer
+	_, err = bufw.Write(requestBytes.Bytes())
+	if err != nil {
+		err = timeoutError(ctx, StageRead, r.ReadTimeout, err)
+		log.Error().Err(err).Msg("bufw.Write(requestBytes)")
+		return nil, nil, err
+	}
+
+	// writes buffer data into connection io.Writer
+	err = bufw.Flush()
+	if err != nil {
+		err = timeoutError(ctx, StageRead, r.ReadTimeout, err)
+		log.Error().Err(err).Msg("bufw.Flush()")
+		return nil, nil, err
+	}
+
+	// read response
+	resp, err := http.ReadResponse(bufr, request)
+	if err != nil {
+		err = timeoutError(ctx, StageRead, r.ReadTimeout, err)
+		log.Error().Err(err).Msg("http.ReadResponse(bufr, request)")
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// reads response body, the next response starts after it
+	msg, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = timeoutError(ctx, StageRead, r.ReadTimeout, err)
+		log.Error().Err(err).Msg("ioutil.ReadAll(resp.Body)")
+		return nil, nil, err
+	}
+	log.Trace().Msg("response data:")
+	log.Trace().Msg(string(msg))
+
+	// cookies replace earlier cookies of the same name, domain and path
+	jar.SetCookies(request.URL, resp.Cookies())
+
+	return requestBytes.Bytes(), resp, nil
+}
+
+// checkContentType compares the response media type against the expected type
+func checkContentType(resp *http.Response, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.EqualFold(mediaType, expected) {
+		return fmt.Errorf("response content type %q, expected %q", resp.Header.Get("Content-Type"), expected)
+	}
+	return nil
+}
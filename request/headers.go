@@ -0,0 +1,88 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ${NAME} references to environment variables in header templates
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// headers set by the http request serialization which must not be overwritten
+var managedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// LoadHeaders reads a json object of header names and value templates
+func LoadHeaders(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile")
+		return nil, err
+	}
+	headers := make(map[string]string)
+	err = json.Unmarshal(data, &headers)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &headers)")
+		return nil, err
+	}
+	return headers, nil
+}
+
+// ExpandEnv substitutes ${NAME} references of a header template with the
+// values of the environment variables, unset variables are an error so
+// that requests never go out with an empty secret
+func ExpandEnv(template string) (string, error) {
+	var missing []string
+	value := envReference.ReplaceAllStringFunc(template, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return value, nil
+}
+
+// setHeaders expands the header templates and sets them on the request,
+// only header names are logged as values may hold secrets
+func setHeaders(request *http.Request, headers map[string]string) error {
+
+	// sorted for deterministic request bytes
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if managedHeaders[canonical] {
+			return fmt.Errorf("header %s is set by the client and cannot be configured", canonical)
+		}
+		value, err := ExpandEnv(headers[name])
+		if err != nil {
+			return fmt.Errorf("header %s: %w", canonical, err)
+		}
+		if canonical == "Host" {
+			request.Host = value
+		} else {
+			request.Header.Set(canonical, value)
+		}
+		log.Trace().Str("header", canonical).Msg("request header set.")
+	}
+	return nil
+}
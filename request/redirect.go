@@ -0,0 +1,143 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// redirect status codes which are followed
+var redirectStatus = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// headers which are only sent to the origin host and its subdomains
+var credentialHeaders = map[string]bool{
+	"authorization":    true,
+	"www-authenticate": true,
+	"cookie":           true,
+	"cookie2":          true,
+}
+
+// redirectLocation returns the resolved location of a redirect response
+// of the exchange or nil if the response is not followed
+func (r *RequestTLS) redirectLocation(ex Exchange, resp *http.Response, redirects int) (*url.URL, error) {
+	if r.MaxRedirects <= 0 || !redirectStatus[resp.StatusCode] || resp.Header.Get("Location") == "" {
+		return nil, nil
+	}
+	if redirects >= r.MaxRedirects {
+		return nil, fmt.Errorf("stopped after %d redirects", r.MaxRedirects)
+	}
+
+	// relative locations resolve against the request url
+	base, err := url.Parse("https://" + r.serverHost() + ex.Path)
+	if err != nil {
+		return nil, err
+	}
+	location, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect location: %w", err)
+	}
+	if location.Scheme != "https" {
+		return nil, fmt.Errorf("redirect to scheme %q, only https is attested", location.Scheme)
+	}
+	if location.User != nil {
+		return nil, fmt.Errorf("redirect location with user info is not supported")
+	}
+	return location, nil
+}
+
+// sameHost reports whether the location is served on the attested session
+func (r *RequestTLS) sameHost(location *url.URL) bool {
+	port := location.Port()
+	if port == "" {
+		port = strconv.Itoa(defaultPort)
+	}
+	serverPort := r.ServerPort
+	if serverPort == 0 {
+		serverPort = defaultPort
+	}
+	return strings.EqualFold(location.Hostname(), r.ServerDomain) && port == strconv.Itoa(serverPort)
+}
+
+// redirectExchange returns the exchange requesting the redirect location,
+// 301, 302 and 303 redirects continue with GET, 307 and 308 keep method and body
+func redirectExchange(ex Exchange, status int, location *url.URL) Exchange {
+	next := ex
+	next.Path = location.RequestURI()
+	if status != http.StatusTemporaryRedirect && status != http.StatusPermanentRedirect && ex.Method != http.MethodGet {
+		next.Method = http.MethodGet
+		next.Body = nil
+		next.ContentType = ""
+	}
+	return next
+}
+
+// redirectRequest returns the request of a cross-host redirect on a new proxied session,
+// credentials, client certificates and headers referencing environment variables
+// are only forwarded to subdomains of the origin host
+func (r *RequestTLS) redirectRequest(ex Exchange, location *url.URL, redirects int) (*RequestTLS, error) {
+	next := *r
+	next.ServerDomain = location.Hostname()
+	next.ServerPort = 0
+	if location.Port() != "" {
+		next.ServerPort, _ = strconv.Atoi(location.Port())
+	}
+	next.ServerPath = ex.Path
+	next.UrlPrivateParts = ""
+	next.Method = ex.Method
+	next.Body = ex.Body
+	next.ContentType = ex.ContentType
+	next.Exchanges = nil
+	next.redirects = redirects
+
+	// request headers of the exchange apply to the first request of the new session
+	origin := strings.ToLower(r.ServerDomain)
+	host := strings.ToLower(location.Hostname())
+	trusted := host == origin || strings.HasSuffix(host, "."+origin)
+
+	// ca bundle and pins are scoped to the origin host, other hosts are verified
+	// against the system pool and their own pins, which are required if the
+	// origin trust is restricted
+	if host != origin {
+		pins, ok := redirectPins(r.RedirectPins, host)
+		if !ok && (len(r.PinnedSPKI) > 0 || r.CABundle != "" || !r.SystemRoots) {
+			return nil, fmt.Errorf("redirect to %s requires pins of the host in redirect_pins", host)
+		}
+		next.PinnedSPKI = pins
+		next.CABundle = ""
+		next.SystemRoots = true
+	}
+
+	if !trusted {
+		next.AccessToken = ""
+		next.ClientCertificate = ""
+		next.ClientKey = ""
+	}
+	next.Headers = map[string]string{}
+	for _, headers := range []map[string]string{r.Headers, ex.Headers} {
+		for name, value := range headers {
+			// templates may expand to secrets of the origin host
+			if trusted || (!credentialHeaders[strings.ToLower(name)] && !envReference.MatchString(value)) {
+				next.Headers[name] = value
+			}
+		}
+	}
+	return &next, nil
+}
+
+// redirectPins returns the pins configured for the host
+func redirectPins(pins map[string][]string, host string) ([]string, bool) {
+	for name, hostPins := range pins {
+		if strings.EqualFold(name, host) {
+			return hostPins, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,118 @@
+package request
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedirectRequest(t *testing.T) {
+	r := RequestTLS{
+		ServerDomain:      "api.example.com",
+		AccessToken:       "token",
+		ClientCertificate: "prover.pem",
+		ClientKey:         "prover.key",
+		SystemRoots:       true,
+		Headers: map[string]string{
+			"Authorization": "Basic abc",
+			"X-Api-Key":     "${API_KEY}",
+			"X-Tenant":      "tenant-${TENANT}-eu",
+			"Accept":        "application/json",
+		},
+	}
+	ex := Exchange{Path: "/a", Method: "GET", Headers: map[string]string{
+		"X-Session": "${SESSION}",
+		"X-Trace":   "1",
+	}}
+	all := map[string]string{
+		"Authorization": "Basic abc",
+		"X-Api-Key":     "${API_KEY}",
+		"X-Tenant":      "tenant-${TENANT}-eu",
+		"Accept":        "application/json",
+		"X-Session":     "${SESSION}",
+		"X-Trace":       "1",
+	}
+
+	tests := []struct {
+		name     string
+		location string
+		trusted  bool
+		headers  map[string]string
+	}{
+		{"same host other port", "https://api.example.com:8443/b", true, all},
+		{"subdomain", "https://eu.api.example.com/b", true, all},
+		{"other host", "https://cdn.example.net/b", false, map[string]string{"Accept": "application/json", "X-Trace": "1"}},
+		{"parent domain", "https://example.com/b", false, map[string]string{"Accept": "application/json", "X-Trace": "1"}},
+		{"suffix without dot", "https://evilapi.example.com/b", false, map[string]string{"Accept": "application/json", "X-Trace": "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := url.Parse(tt.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := r.redirectRequest(redirectExchange(ex, 302, location), location, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(next.Headers, tt.headers) {
+				t.Errorf("headers %v, want %v", next.Headers, tt.headers)
+			}
+			if (next.AccessToken != "") != tt.trusted || (next.ClientCertificate != "") != tt.trusted || (next.ClientKey != "") != tt.trusted {
+				t.Errorf("token %q and certificate %q forwarded to trusted %v host", next.AccessToken, next.ClientCertificate, tt.trusted)
+			}
+			if next.ServerDomain != location.Hostname() || next.ServerPath != "/b" {
+				t.Errorf("next request to %s%s", next.ServerDomain, next.ServerPath)
+			}
+		})
+	}
+}
+
+func TestRedirectTrust(t *testing.T) {
+	pinned := RequestTLS{
+		ServerDomain: "api.example.com",
+		CABundle:     "ca.pem",
+		PinnedSPKI:   []string{"origin"},
+		RedirectPins: map[string][]string{"CDN.example.net": {"cdn"}},
+	}
+	ex := Exchange{Path: "/a", Method: "GET"}
+
+	tests := []struct {
+		name     string
+		r        RequestTLS
+		location string
+		pins     []string
+		caBundle string
+		err      string
+	}{
+		{"same host keeps origin trust", pinned, "https://api.example.com:8443/b", []string{"origin"}, "ca.pem", ""},
+		{"pinned redirect host", pinned, "https://cdn.example.net/b", []string{"cdn"}, "", ""},
+		{"subdomain without pins", pinned, "https://eu.api.example.com/b", nil, "", "requires pins"},
+		{"other host without pins", pinned, "https://other.example.org/b", nil, "", "requires pins"},
+		{"unrestricted origin", RequestTLS{ServerDomain: "api.example.com", SystemRoots: true}, "https://other.example.org/b", nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := url.Parse(tt.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := tt.r.redirectRequest(redirectExchange(ex, 302, location), location, 1)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(next.PinnedSPKI, tt.pins) || next.CABundle != tt.caBundle {
+				t.Errorf("pins %v and ca bundle %q, want %v and %q", next.PinnedSPKI, next.CABundle, tt.pins, tt.caBundle)
+			}
+		})
+	}
+}
@@ -2,14 +2,19 @@ package request
 
 import (
 	"bufio"
+	"client/credentials"
 	tls "client/tls-fork"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-
-	// "crypto/tls"
-	"crypto/x509"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -22,25 +27,116 @@ type RequestTLS struct {
 	UrlPrivateParts string
 	AccessToken     string
 	StorageLocation string
+	// credential file whose access token replaces AccessToken,
+	// expired tokens are refreshed before the call
+	Credential string
+	// http method, body and content type of the request
+	Method      string
+	Body        []byte
+	ContentType string
+	// additional request headers of all exchanges, values may
+	// reference environment variables as ${NAME}
+	Headers map[string]string
+	// exchanges sent after the first request on the same session
+	Exchanges []Exchange
+	// media type the response must have, any if empty
+	ExpectedContentType string
+	// offered key exchange groups by name in order of preference
+	Curves []string
+	// timeouts of the tcp connection to the proxy, the tls handshake
+	// and each request-response exchange, zero disables a timeout
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	ReadTimeout      time.Duration
+	// maximum number of followed redirects, zero disables following
+	MaxRedirects int
+	// port of the server, 443 if zero
+	ServerPort int
+	// pem bundle of trusted ca certificates, in addition to the
+	// system pool if SystemRoots is set
+	CABundle    string
+	SystemRoots bool
+	// base64 encoded sha256 hashes of SubjectPublicKeyInfos, one must
+	// match a certificate of the verified server chain if set
+	PinnedSPKI []string
+	// pins of the hosts cross-host redirects lead to, the ca bundle
+	// and the pins above only apply to the server domain
+	RedirectPins map[string][]string
+	// pem files of the client certificate and key presented
+	// if the server requests client authentication
+	ClientCertificate string
+	ClientKey         string
+
+	// redirects followed before the session
+	redirects int
 }
 
 type RequestData struct {
 	secrets   map[string][]byte
 	recordMap map[string]tls.RecordMeta
+	// exchanges sent as client application traffic in order
+	exchanges []sentExchange
+	// negotiated cipher suite and server of the session
+	cipherSuite  uint16
+	serverDomain string
+	// server requested client authentication
+	clientAuth bool
+	// der encoded peer certificates and encrypted server
+	// handshake records in order of their sequence number
+	peerCertificates [][]byte
+	handshakeRecords [][]byte
+	// sessions of earlier hops which redirected to another host
+	hops []RequestData
 }
 
 func NewRequest(serverDomain string, serverPath string, proxyURL string) RequestTLS {
 	return RequestTLS{
-		ServerDomain:    serverDomain,
-		ServerPath:      serverPath, // "testserver.origodata.io"
-		ProxyURL:        proxyURL,
-		UrlPrivateParts: "",
-		AccessToken:     "",
-		StorageLocation: "./local_storage/",
+		ServerDomain:     serverDomain,
+		ServerPath:       serverPath, // "testserver.origodata.io"
+		ProxyURL:         proxyURL,
+		UrlPrivateParts:  "",
+		AccessToken:      "",
+		StorageLocation:  "./local_storage/",
+		Method:           http.MethodGet,
+		Body:             nil,
+		ContentType:      "application/json",
+		Headers:          map[string]string{},
+		DialTimeout:      DefaultDialTimeout,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		ReadTimeout:      DefaultReadTimeout,
+		SystemRoots:      true,
 	}
 }
 
+// methods which may carry a request body
+var bodyMethods = map[string]bool{
+	http.MethodPost:  true,
+	http.MethodPut:   true,
+	http.MethodPatch: true,
+}
+
+// Store writes the session of the final response to session_params_13.json and
+// sessions of earlier cross-host redirect hops to session_params_13_hop<n>.json
 func (r *RequestTLS) Store(data RequestData) error {
+	for i, hop := range data.hops {
+		err := storeSession(hop, i, fmt.Sprintf("%ssession_params_13_hop%d.json", r.StorageLocation, i))
+		if err != nil {
+			return err
+		}
+	}
+
+	// hop transcripts of earlier calls do not belong to this session
+	for i := len(data.hops); ; i++ {
+		err := os.Remove(fmt.Sprintf("%ssession_params_13_hop%d.json", r.StorageLocation, i))
+		if err != nil {
+			break
+		}
+	}
+	return storeSession(data, len(data.hops), r.StorageLocation+"session_params_13.json")
+}
+
+// storeSession writes secrets, records and request metadata of a session
+func storeSession(data RequestData, hop int, path string) error {
 	jsonData := make(map[string]map[string]string)
 	jsonData["keys"] = make(map[string]string)
 
@@ -55,12 +151,58 @@ func (r *RequestTLS) Store(data RequestData) error {
 		jsonData[k]["ciphertext"] = hex.EncodeToString(v.Ciphertext)
 	}
 
+	// negotiated session parameters, redirects lists the exchanges whose
+	// responses were followed on the session and final_exchange the
+	// exchange of the final response
+	var redirects []string
+	for i, ex := range data.exchanges {
+		if ex.redirected {
+			redirects = append(redirects, fmt.Sprint(i))
+		}
+	}
+	jsonData["session"] = map[string]string{
+		"cipher_suite":   tls.CipherSuiteName(data.cipherSuite),
+		"server_domain":  data.serverDomain,
+		"hop":            fmt.Sprint(hop),
+		"redirects":      strings.Join(redirects, ","),
+		"final_exchange": fmt.Sprint(len(data.exchanges) - 1),
+		"client_auth":    fmt.Sprint(data.clientAuth),
+	}
+
+	// server identity, the handshake records hold the Certificate and
+	// CertificateVerify messages which bind the chain to the transcript
+	jsonData["certificates"] = make(map[string]string)
+	for i, cert := range data.peerCertificates {
+		jsonData["certificates"][fmt.Sprint(i)] = hex.EncodeToString(cert)
+	}
+	jsonData["handshake"] = make(map[string]string)
+	for i, record := range data.handshakeRecords {
+		jsonData["handshake"][fmt.Sprintf("%016x", i)] = hex.EncodeToString(record)
+	}
+
+	// request metadata per exchange to check the client records against,
+	// bodies are not stored as they may contain credentials
+	for i, ex := range data.exchanges {
+		requestHash := sha256.Sum256(ex.request)
+		jsonData[fmt.Sprintf("request_%d", i)] = map[string]string{
+			"method":         ex.Method,
+			"path":           ex.Path,
+			"content_type":   ex.ContentType,
+			"content_length": fmt.Sprint(len(ex.Body)),
+			"length":         fmt.Sprint(len(ex.request)),
+			"sha256":         hex.EncodeToString(requestHash[:]),
+			"status":         fmt.Sprint(ex.status),
+			// sequence number of the first client record of the request
+			"sequence": fmt.Sprintf("%016x", ex.sequence),
+		}
+	}
+
 	file, err := json.MarshalIndent(jsonData, "", " ")
 	if err != nil {
 		log.Error().Err(err).Msg("json.MarshalIndent")
 		return err
 	}
-	err = ioutil.WriteFile(r.StorageLocation+"session_params_13.json", file, 0644)
+	err = ioutil.WriteFile(path, file, 0644)
 	if err != nil {
 		log.Error().Err(err).Msg("ioutil.WriteFile")
 	}
@@ -68,111 +210,261 @@ func (r *RequestTLS) Store(data RequestData) error {
 }
 
 func (r *RequestTLS) Call(hsOnly bool) (RequestData, error) {
+	return r.CallContext(context.Background(), hsOnly)
+}
+
+// CallContext performs the request via the proxy, cancellation of ctx
+// aborts the call and stalls beyond the configured timeouts return a TimeoutError,
+// redirects to another host are followed on new proxied sessions
+func (r *RequestTLS) CallContext(ctx context.Context, hsOnly bool) (RequestData, error) {
+
+	// access token of the credential
+	if r.Credential != "" {
+		cc, err := credentials.NewCredsClientFromFile(r.Credential)
+		if err != nil {
+			log.Error().Err(err).Msg("credentials.NewCredsClientFromFile()")
+			return RequestData{}, err
+		}
+		r.AccessToken, err = cc.Token()
+		if err != nil {
+			log.Error().Err(err).Msg("cc.Token()")
+			return RequestData{}, err
+		}
+	}
+
+	var hops []RequestData
+	request := r
+	for {
+		data, next, err := request.callSession(ctx, hsOnly)
+		if err != nil {
+			return RequestData{}, err
+		}
+		if next == nil {
+			data.hops = hops
+			return data, nil
+		}
+		log.Debug().Str("server", next.ServerDomain).Msg("following redirect on a new session.")
+		hops = append(hops, data)
+		request = next
+	}
+}
+
+// callSession performs the exchanges on a single tls session and returns the
+// request of the next session if the final response redirects to another host
+func (r *RequestTLS) callSession(ctx context.Context, hsOnly bool) (RequestData, *RequestTLS, error) {
+
+	// request methods and bodies
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	exchanges := r.exchanges()
+	for i, ex := range exchanges {
+		err := ex.checkMethod()
+		if err != nil {
+			log.Error().Err(err).Int("exchange", i).Msg("ex.checkMethod()")
+			return RequestData{}, nil, err
+		}
+	}
+
+	// key exchange groups
+	groupIDs, err := curveIDs(r.Curves)
+	if err != nil {
+		log.Error().Err(err).Msg("curveIDs(r.Curves)")
+		return RequestData{}, nil, err
+	}
+
+	if r.ServerPort < 0 || r.ServerPort > 65535 {
+		err = fmt.Errorf("invalid server port %d", r.ServerPort)
+		log.Error().Err(err).Msg("r.ServerPort")
+		return RequestData{}, nil, err
+	}
 
 	// tls configs
 	config := &tls.Config{
 		InsecureSkipVerify:       false,
-		CurvePreferences:         []tls.CurveID{tls.CurveP256},
+		CurvePreferences:         groupIDs,
 		PreferServerCipherSuites: false,
 		MinVersion:               tls.VersionTLS13,
 		MaxVersion:               tls.VersionTLS13,
+		// the circuits implement the sha256 key schedule with aes-128-gcm records only
 		CipherSuites: []uint16{
 			tls.TLS_AES_128_GCM_SHA256,
 		},
 		ServerName: r.ServerDomain,
 	}
 
-	// local server testing settings
-	if r.ServerDomain == "localhost" {
-		PathCaCrt := "certs/certificates/ca.crt"
-
-		// set up cert verification
-		caCert, _ := ioutil.ReadFile(PathCaCrt)
-		caCertPool, _ := x509.SystemCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-		config.RootCAs = caCertPool
+	// client certificate, presented only if the server requests
+	// client authentication with a CertificateRequest
+	clientCert, err := r.loadClientCertificate()
+	if err != nil {
+		log.Error().Err(err).Msg("r.loadClientCertificate()")
+		return RequestData{}, nil, err
+	}
+	clientAuth := false
+	if clientCert != nil {
+		config.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			clientAuth = true
+			if err := info.SupportsCertificate(clientCert); err != nil {
+				log.Debug().Err(err).Msg("client certificate does not match the certificate request.")
+			}
+			return clientCert, nil
+		}
+	}
 
-		r.ServerDomain += ":8081"
+	// trusted roots and pinned server keys
+	config.RootCAs, err = r.rootCAs()
+	if err != nil {
+		log.Error().Err(err).Msg("r.rootCAs()")
+		return RequestData{}, nil, err
+	}
+	pins, err := r.spkiPins()
+	if err != nil {
+		log.Error().Err(err).Msg("r.spkiPins()")
+		return RequestData{}, nil, err
+	}
+	if len(pins) > 0 {
+		config.VerifyConnection = verifyPins(pins)
 	}
 
 	// measure start time
 	start := time.Now()
 
-	// tls connection
-	conn, err := tls.Dial("tcp", r.ProxyURL, config)
+	// tcp connection to proxy
+	dialCtx, cancel := stageContext(ctx, r.DialTimeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	rawConn, err := dialer.DialContext(dialCtx, "tcp", r.ProxyURL)
 	if err != nil {
-		log.Error().Err(err).Msg("tls.Dial()")
-		return RequestData{}, err
+		err = timeoutError(ctx, StageDial, r.DialTimeout, err)
+		log.Error().Err(err).Msg("dialer.DialContext()")
+		return RequestData{}, nil, err
 	}
+	stopWatch := watchContext(ctx, rawConn)
+	defer stopWatch()
+
+	// tls connection
+	recorder := newRecordingConn(rawConn)
+	conn := tls.Client(recorder, config)
 	defer conn.Close()
+	handshakeCtx, cancel := stageContext(ctx, r.HandshakeTimeout)
+	defer cancel()
+	err = conn.HandshakeContext(handshakeCtx)
+	if err != nil {
+		err = timeoutError(ctx, StageHandshake, r.HandshakeTimeout, err)
+		log.Error().Err(err).Msg("conn.HandshakeContext()")
+		return RequestData{}, nil, err
+	}
+
+	recorder.stop()
 
 	// tls handshake time
 	elapsed := time.Since(start)
 	log.Debug().Str("time", elapsed.String()).Msg("client tls handshake took.")
-	// state := conn.ConnectionState()
+	state := conn.ConnectionState()
+	log.Debug().Str("cipher_suite", tls.CipherSuiteName(state.CipherSuite)).Bool("client_auth", clientAuth).Msg("negotiated cipher suite.")
 
-	// return here if handshakeOnly flag set
-	if hsOnly {
-		return RequestData{}, nil
+	peerCertificates := make([][]byte, len(state.PeerCertificates))
+	for i, cert := range state.PeerCertificates {
+		peerCertificates[i] = cert.Raw
 	}
 
-	// server settings
-	serverURL := "https://" + r.ServerDomain + r.ServerPath
-	if r.UrlPrivateParts != "" {
-		serverURL += r.UrlPrivateParts
+	// return here if handshakeOnly flag set
+	if hsOnly {
+		return RequestData{}, nil, nil
 	}
 
-	// measure request-response roundtrip
+	// measure request-response roundtrips
 	start = time.Now()
 
-	// build request
-	request, _ := http.NewRequest(http.MethodGet, serverURL, nil)
-	request.Close = false
-
-	// request headers
-	request.Header.Set("Content-Type", "application/json")
-	if r.AccessToken != "" {
-		request.Header.Set("Authorization", "Bearer "+r.AccessToken)
-	}
-
 	// initialize connection buffers
 	bufr := bufio.NewReader(conn)
 	bufw := bufio.NewWriter(conn)
 
-	// write request to connection buffer
-	err = request.Write(bufw)
+	// exchanges in order on the same session, responses are read
+	// completely before the next request is sent
+	var sent []sentExchange
+	jar, err := cookiejar.New(nil)
 	if err != nil {
-		log.Error().Err(err).Msg("request.Write(bufw)")
-		return RequestData{}, err
+		log.Error().Err(err).Msg("cookiejar.New()")
+		return RequestData{}, nil, err
 	}
+	var next *RequestTLS
+	redirects := r.redirects
+	for i := 0; i < len(exchanges); i++ {
+		ex := exchanges[i]
+		// every exchange gets the full read timeout
+		err = exchangeDeadline(ctx, rawConn, r.ReadTimeout)
+		if err != nil {
+			log.Error().Err(err).Int("exchange", i).Msg("exchangeDeadline()")
+			return RequestData{}, nil, err
+		}
+		sequence := recorder.sequence()
+		requestBytes, resp, err := r.roundTrip(ctx, bufr, bufw, ex, jar)
+		if err != nil {
+			log.Error().Int("exchange", i).Msg("r.roundTrip()")
+			return RequestData{}, nil, err
+		}
+		sent = append(sent, sentExchange{Exchange: ex, request: requestBytes, sequence: sequence, status: resp.StatusCode})
 
-	// writes buffer data into connection io.Writer
-	err = bufw.Flush()
-	if err != nil {
-		log.Error().Err(err).Msg("bufw.Flush()")
-		return RequestData{}, err
-	}
+		// redirects on the same host continue on the session,
+		// redirects to another host end it
+		location, err := r.redirectLocation(ex, resp, redirects)
+		if err != nil {
+			log.Error().Err(err).Int("exchange", i).Msg("r.redirectLocation()")
+			return RequestData{}, nil, err
+		}
+		if location != nil {
+			redirects++
+			sent[len(sent)-1].redirected = true
+			redirected := redirectExchange(ex, resp.StatusCode, location)
+			if !r.sameHost(location) {
+				if i < len(exchanges)-1 {
+					err = fmt.Errorf("exchange %d redirects to another host, only the last exchange may leave the session", i)
+					log.Error().Err(err).Msg("r.sameHost(location)")
+					return RequestData{}, nil, err
+				}
+				next, err = r.redirectRequest(redirected, location, redirects)
+				if err != nil {
+					log.Error().Err(err).Msg("r.redirectRequest()")
+					return RequestData{}, nil, err
+				}
+				break
+			}
+			if resp.Close {
+				err = fmt.Errorf("server closed the session on redirect of exchange %d", i)
+				log.Error().Err(err).Msg("resp.Close")
+				return RequestData{}, nil, err
+			}
+			exchanges = append(exchanges[:i+1], append([]Exchange{redirected}, exchanges[i+1:]...)...)
+			continue
+		}
 
-	// read response
-	resp, err := http.ReadResponse(bufr, request)
-	if err != nil {
-		log.Error().Err(err).Msg("http.ReadResponse(bufr, request)")
-		return RequestData{}, err
+		// response content type
+		err = checkContentType(resp, r.ExpectedContentType)
+		if err != nil {
+			log.Error().Err(err).Int("exchange", i).Msg("checkContentType()")
+			return RequestData{}, nil, err
+		}
+		if resp.Close && i < len(exchanges)-1 {
+			err = fmt.Errorf("server closed the session after exchange %d of %d", i, len(exchanges))
+			log.Error().Err(err).Msg("resp.Close")
+			return RequestData{}, nil, err
+		}
 	}
-	defer resp.Body.Close()
-
-	// reads response body
-	msg, _ := ioutil.ReadAll(resp.Body)
-	log.Trace().Msg("response data:")
-	log.Trace().Msg(string(msg))
 
 	// catch time
 	elapsed = time.Since(start)
-	log.Debug().Str("time", elapsed.String()).Msg("client request-response roundtrip took.")
+	log.Debug().Str("time", elapsed.String()).Int("exchanges", len(exchanges)).Msg("client request-response roundtrips took.")
 
 	// access to recorded session data
 	return RequestData{
-		secrets:   conn.GetSecretMap(),
-		recordMap: conn.GetRecordMap(),
-	}, nil
+		secrets:          conn.GetSecretMap(),
+		recordMap:        conn.GetRecordMap(),
+		exchanges:        sent,
+		cipherSuite:      state.CipherSuite,
+		serverDomain:     r.serverHost(),
+		clientAuth:       clientAuth,
+		peerCertificates: peerCertificates,
+		handshakeRecords: recorder.records,
+	}, next, nil
 }
@@ -0,0 +1,239 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"client/credentials"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec describes a request to attest, kept as yaml or json file
+// so that attestations can be versioned next to their policies
+type Spec struct {
+	// https url of the resource including path and query
+	URL    string `json:"url" yaml:"url"`
+	Method string `json:"method" yaml:"method"`
+	// header value templates, may reference environment variables as ${NAME}
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	// request body given inline or by file path relative to the spec
+	Body        string `json:"body" yaml:"body"`
+	BodyFile    string `json:"body_file" yaml:"body_file"`
+	ContentType string `json:"content_type" yaml:"content_type"`
+	// credential file providing access token and private url parts
+	Credential string `json:"credential" yaml:"credential"`
+	// policy file or bundle directory and policy name of the bundle
+	Policy     string `json:"policy" yaml:"policy"`
+	PolicyName string `json:"policy_name" yaml:"policy_name"`
+	// media type the response must have, e.g. application/json
+	ExpectedContentType string `json:"expected_content_type" yaml:"expected_content_type"`
+	// offered key exchange groups in order of preference
+	Curves []string `json:"curves" yaml:"curves"`
+	// maximum number of followed redirects, zero disables following
+	MaxRedirects int `json:"max_redirects" yaml:"max_redirects"`
+	// pem bundle of trusted ca certificates, the system pool is trusted
+	// unless system_roots is false, and pinned server keys
+	CABundle    string   `json:"ca_bundle" yaml:"ca_bundle"`
+	SystemRoots *bool    `json:"system_roots" yaml:"system_roots"`
+	PinnedSPKI  []string `json:"pinned_spki" yaml:"pinned_spki"`
+	// pinned server keys per host of cross-host redirects
+	RedirectPins map[string][]string `json:"redirect_pins" yaml:"redirect_pins"`
+	// pem files of the client certificate and key for mutual tls
+	ClientCertificate string `json:"client_certificate" yaml:"client_certificate"`
+	ClientKey         string `json:"client_key" yaml:"client_key"`
+
+	// requests sent after the first request on the same tls session,
+	// e.g. a data call following a login call
+	Exchanges []SpecExchange `json:"exchanges" yaml:"exchanges"`
+
+	// directory of the spec file, relative paths resolve against it
+	dir string
+}
+
+// SpecExchange describes a request following the first request of a spec,
+// headers of the spec apply to all exchanges
+type SpecExchange struct {
+	// server path including query, the host is the host of the spec url
+	Path        string            `json:"path" yaml:"path"`
+	Method      string            `json:"method" yaml:"method"`
+	Headers     map[string]string `json:"headers" yaml:"headers"`
+	Body        string            `json:"body" yaml:"body"`
+	BodyFile    string            `json:"body_file" yaml:"body_file"`
+	ContentType string            `json:"content_type" yaml:"content_type"`
+}
+
+// LoadSpec reads a request spec file, files ending in .yaml or .yml
+// are parsed as yaml and all other files as json, unknown fields are rejected
+func LoadSpec(path string) (Spec, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile")
+		return Spec{}, err
+	}
+
+	var spec Spec
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&spec)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&spec)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("decoder.Decode(&spec)")
+		return Spec{}, fmt.Errorf("request spec %s: %w", path, err)
+	}
+	spec.dir = filepath.Dir(path)
+
+	if spec.URL == "" {
+		return Spec{}, fmt.Errorf("request spec %s: url required", path)
+	}
+	if spec.Body != "" && spec.BodyFile != "" {
+		return Spec{}, fmt.Errorf("request spec %s: body not allowed next to body_file", path)
+	}
+	if (spec.ClientCertificate == "") != (spec.ClientKey == "") {
+		return Spec{}, fmt.Errorf("request spec %s: client_certificate and client_key must be set together", path)
+	}
+	if spec.MaxRedirects < 0 {
+		return Spec{}, fmt.Errorf("request spec %s: max_redirects must not be negative", path)
+	}
+	for i, ex := range spec.Exchanges {
+		if !strings.HasPrefix(ex.Path, "/") {
+			return Spec{}, fmt.Errorf("request spec %s: exchanges[%d].path must start with /", path, i)
+		}
+		if ex.Body != "" && ex.BodyFile != "" {
+			return Spec{}, fmt.Errorf("request spec %s: exchanges[%d].body not allowed next to body_file", path, i)
+		}
+	}
+	return spec, nil
+}
+
+// Path resolves a path of the spec relative to the spec file
+func (s Spec) Path(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(s.dir, path)
+}
+
+// NewRequest builds the request described by the spec, sent via the proxy at proxyURL
+func (s Spec) NewRequest(proxyURL string) (RequestTLS, error) {
+
+	// server domain and path
+	serverURL, err := url.Parse(s.URL)
+	if err != nil {
+		return RequestTLS{}, fmt.Errorf("url: %w", err)
+	}
+	if serverURL.Scheme != "https" {
+		return RequestTLS{}, fmt.Errorf("url: scheme must be https, got %q", serverURL.Scheme)
+	}
+	if serverURL.User != nil {
+		return RequestTLS{}, errors.New("url: user info not allowed, use headers or credential")
+	}
+	serverPath := serverURL.EscapedPath()
+	if serverURL.RawQuery != "" {
+		serverPath += "?" + serverURL.RawQuery
+	}
+
+	r := NewRequest(serverURL.Hostname(), serverPath, proxyURL)
+	if serverURL.Port() != "" {
+		r.ServerPort, err = strconv.Atoi(serverURL.Port())
+		if err != nil {
+			return RequestTLS{}, fmt.Errorf("url: invalid port %q", serverURL.Port())
+		}
+	}
+	if s.Method != "" {
+		r.Method = strings.ToUpper(s.Method)
+	}
+	if s.ContentType != "" {
+		r.ContentType = s.ContentType
+	}
+	r.ExpectedContentType = s.ExpectedContentType
+	r.Curves = s.Curves
+	r.MaxRedirects = s.MaxRedirects
+	r.CABundle = s.Path(s.CABundle)
+	if s.SystemRoots != nil {
+		r.SystemRoots = *s.SystemRoots
+	}
+	r.PinnedSPKI = s.PinnedSPKI
+	r.RedirectPins = s.RedirectPins
+	if s.ClientCertificate != "" {
+		r.ClientCertificate = s.Path(s.ClientCertificate)
+		r.ClientKey = s.Path(s.ClientKey)
+	}
+	for name, value := range s.Headers {
+		r.Headers[name] = value
+	}
+
+	// request body
+	if s.Body != "" {
+		r.Body = []byte(s.Body)
+	}
+	if s.BodyFile != "" {
+		r.Body, err = os.ReadFile(s.Path(s.BodyFile))
+		if err != nil {
+			log.Error().Err(err).Msg("os.ReadFile")
+			return RequestTLS{}, err
+		}
+	}
+
+	// credential
+	if s.Credential != "" {
+		cred, err := credentials.LoadCredential(s.Path(s.Credential))
+		if err != nil {
+			return RequestTLS{}, err
+		}
+		r.AccessToken = cred.AccessToken
+		r.UrlPrivateParts = cred.UrlPrivateParts
+		r.Credential = s.Path(s.Credential)
+	}
+
+	// exchanges following the first request
+	for _, specEx := range s.Exchanges {
+		ex := Exchange{
+			Path:        specEx.Path,
+			Method:      http.MethodGet,
+			ContentType: r.ContentType,
+			Headers:     specEx.Headers,
+		}
+		if specEx.Method != "" {
+			ex.Method = strings.ToUpper(specEx.Method)
+		}
+		if specEx.ContentType != "" {
+			ex.ContentType = specEx.ContentType
+		}
+		if specEx.Body != "" {
+			ex.Body = []byte(specEx.Body)
+		}
+		if specEx.BodyFile != "" {
+			ex.Body, err = os.ReadFile(s.Path(specEx.BodyFile))
+			if err != nil {
+				log.Error().Err(err).Msg("os.ReadFile")
+				return RequestTLS{}, err
+			}
+		}
+		r.Exchanges = append(r.Exchanges, ex)
+	}
+
+	for i, ex := range r.exchanges() {
+		err = ex.checkMethod()
+		if err != nil {
+			return RequestTLS{}, fmt.Errorf("exchange %d: %w", i, err)
+		}
+	}
+	return r, nil
+}
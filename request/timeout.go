@@ -0,0 +1,103 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// default timeouts of a call, zero disables a timeout
+const (
+	DefaultDialTimeout      = 10 * time.Second
+	DefaultHandshakeTimeout = 15 * time.Second
+	DefaultReadTimeout      = 30 * time.Second
+)
+
+// stages of a call
+const (
+	StageDial      = "dial"
+	StageHandshake = "handshake"
+	StageRead      = "read"
+)
+
+// stalled party, matched with errors.Is
+var (
+	// the proxy did not accept the tcp connection in time
+	ErrProxyTimeout = errors.New("proxy timeout")
+	// the handshake or an exchange relayed by the proxy did not complete
+	// in time, the client cannot tell whether the proxy did not relay or
+	// the server did not respond
+	ErrSessionTimeout = errors.New("proxied session timeout")
+)
+
+// TimeoutError reports the stage of a call which exceeded its timeout
+type TimeoutError struct {
+	Stage   string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s: %v", e.Stage, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrProxyTimeout for dial stalls and ErrSessionTimeout
+// for handshake and read stalls
+func (e *TimeoutError) Is(target error) bool {
+	if e.Stage == StageDial {
+		return target == ErrProxyTimeout
+	}
+	return target == ErrSessionTimeout
+}
+
+// stageContext derives the context of a call stage with its timeout
+func stageContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError wraps err into a TimeoutError if the stage timed out,
+// cancellation of the parent context is returned as ctx.Err()
+func timeoutError(ctx context.Context, stage string, timeout time.Duration, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", stage, ctx.Err())
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{Stage: stage, Timeout: timeout, Err: err}
+	}
+	return err
+}
+
+// watchContext closes the connection deadline once ctx is done so that
+// blocked reads and writes return, the returned function stops watching
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// exchangeDeadline sets the read timeout of the next request-response
+// exchange, a deadline set on cancellation is not overridden
+func exchangeDeadline(ctx context.Context, conn net.Conn, timeout time.Duration) error {
+	if timeout <= 0 {
+		return ctx.Err()
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	// cancellation may have set its deadline before ours
+	return ctx.Err()
+}
@@ -0,0 +1,109 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTimeoutError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		stage string
+		err   error
+		// errors the result matches
+		proxy   bool
+		session bool
+		timeout bool
+	}{
+		{"dial deadline", context.Background(), StageDial, context.DeadlineExceeded, true, false, true},
+		{"handshake deadline", context.Background(), StageHandshake, context.DeadlineExceeded, false, true, true},
+		{"read deadline", context.Background(), StageRead, os.ErrDeadlineExceeded, false, true, true},
+		{"read eof", context.Background(), StageRead, io.ErrUnexpectedEOF, false, false, false},
+		{"cancelled", cancelled, StageRead, os.ErrDeadlineExceeded, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := timeoutError(tt.ctx, tt.stage, time.Second, tt.err)
+			if errors.Is(err, ErrProxyTimeout) != tt.proxy {
+				t.Errorf("matches ErrProxyTimeout %v, want %v", !tt.proxy, tt.proxy)
+			}
+			if errors.Is(err, ErrSessionTimeout) != tt.session {
+				t.Errorf("matches ErrSessionTimeout %v, want %v", !tt.session, tt.session)
+			}
+			var timeoutErr *TimeoutError
+			if errors.As(err, &timeoutErr) != tt.timeout {
+				t.Errorf("TimeoutError %v, want %v: %v", !tt.timeout, tt.timeout, err)
+			}
+			if tt.timeout && timeoutErr.Stage != tt.stage {
+				t.Errorf("stage %s, want %s", timeoutErr.Stage, tt.stage)
+			}
+		})
+	}
+}
+
+func TestExchangeDeadline(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		timeout time.Duration
+		// read stalls into the deadline
+		expires bool
+		err     bool
+	}{
+		{"timeout", context.Background(), 10 * time.Millisecond, true, false},
+		{"disabled", context.Background(), 0, false, false},
+		{"cancelled", cancelled, 10 * time.Millisecond, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			err := exchangeDeadline(tt.ctx, client, tt.timeout)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// the server never writes, a read returns on the deadline only
+			read := make(chan error, 1)
+			go func() {
+				_, err := client.Read(make([]byte, 1))
+				read <- err
+			}()
+			select {
+			case err := <-read:
+				if !tt.expires {
+					t.Fatalf("read returned without deadline: %v", err)
+				}
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					t.Errorf("read error %v, want deadline exceeded", err)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.expires {
+					t.Fatal("read did not expire")
+				}
+			}
+		})
+	}
+}
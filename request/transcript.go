@@ -0,0 +1,88 @@
+package request
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// tls record header length and content type of encrypted records
+const (
+	recordHeaderLen           = 5
+	recordTypeApplicationData = 23
+)
+
+// recordingConn records the encrypted records the server sends during the
+// handshake, in tls 1.3 they carry the server handshake messages from
+// EncryptedExtensions to the server Finished in order of their sequence number.
+// After the handshake it counts the encrypted records the client writes, their
+// count is the sequence number of the next client application traffic record
+type recordingConn struct {
+	net.Conn
+	recording bool
+	// bytes of an incomplete record
+	pending []byte
+	// encrypted records including the record header
+	records [][]byte
+	// bytes of an incomplete written record
+	pendingWrite []byte
+	// encrypted records written after the handshake
+	written int
+}
+
+func newRecordingConn(conn net.Conn) *recordingConn {
+	return &recordingConn{Conn: conn, recording: true}
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.recording || n == 0 {
+		return n, err
+	}
+
+	// split the received bytes into records
+	c.pending = append(c.pending, b[:n]...)
+	for len(c.pending) >= recordHeaderLen {
+		length := recordHeaderLen + int(binary.BigEndian.Uint16(c.pending[3:5]))
+		if len(c.pending) < length {
+			break
+		}
+		if c.pending[0] == recordTypeApplicationData {
+			c.records = append(c.records, append([]byte(nil), c.pending[:length]...))
+		}
+		c.pending = c.pending[length:]
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.recording || n == 0 {
+		return n, err
+	}
+
+	// count the written bytes as records, every encrypted
+	// record advances the client application traffic sequence
+	c.pendingWrite = append(c.pendingWrite, b[:n]...)
+	for len(c.pendingWrite) >= recordHeaderLen {
+		length := recordHeaderLen + int(binary.BigEndian.Uint16(c.pendingWrite[3:5]))
+		if len(c.pendingWrite) < length {
+			break
+		}
+		if c.pendingWrite[0] == recordTypeApplicationData {
+			c.written++
+		}
+		c.pendingWrite = c.pendingWrite[length:]
+	}
+	return n, err
+}
+
+// sequence returns the sequence number of the next client application traffic record
+func (c *recordingConn) sequence() int {
+	return c.written
+}
+
+// stop ends the recording once the handshake completed
+func (c *recordingConn) stop() {
+	c.recording = false
+	c.pending = nil
+}
@@ -0,0 +1,66 @@
+package request
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// testRecord returns a record of the content type with a payload of n bytes
+func testRecord(contentType byte, n int) []byte {
+	record := []byte{contentType, 0x03, 0x03, byte(n >> 8), byte(n)}
+	return append(record, bytes.Repeat([]byte{0xaa}, n)...)
+}
+
+// discardConn accepts every write
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestRecordingConnWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		// writes after the handshake
+		writes [][]byte
+		// sequence number of the next client record
+		sequence int
+	}{
+		{"no write", nil, 0},
+		{"single record", [][]byte{testRecord(recordTypeApplicationData, 100)}, 1},
+		{"records of one write", [][]byte{append(testRecord(recordTypeApplicationData, 16384+256), testRecord(recordTypeApplicationData, 10)...)}, 2},
+		{"record split across writes", func() [][]byte {
+			record := testRecord(recordTypeApplicationData, 100)
+			return [][]byte{record[:3], record[3:50], record[50:]}
+		}(), 1},
+		{"incomplete record", [][]byte{testRecord(recordTypeApplicationData, 100)[:50]}, 0},
+		{"small records of one request", [][]byte{testRecord(recordTypeApplicationData, 10), testRecord(recordTypeApplicationData, 10), testRecord(recordTypeApplicationData, 10)}, 3},
+		{"plaintext alert", [][]byte{testRecord(21, 2)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newRecordingConn(discardConn{})
+
+			// client Finished written during the handshake does not count
+			_, err := conn.Write(testRecord(recordTypeApplicationData, 53))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.stop()
+
+			for _, b := range tt.writes {
+				n, err := conn.Write(b)
+				if err != nil || n != len(b) {
+					t.Fatalf("Write: %d, %v", n, err)
+				}
+			}
+			if sequence := conn.sequence(); sequence != tt.sequence {
+				t.Errorf("sequence %d, want %d", sequence, tt.sequence)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package request
+
+import (
+	tls "client/tls-fork"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// default port of https servers
+const defaultPort = 443
+
+// serverHost returns the server domain with the port if it is not the default port
+func (r *RequestTLS) serverHost() string {
+	if r.ServerPort == 0 || r.ServerPort == defaultPort {
+		return r.ServerDomain
+	}
+	return net.JoinHostPort(r.ServerDomain, strconv.Itoa(r.ServerPort))
+}
+
+// rootCAs returns the pool of trusted root certificates, nil selects the system pool
+func (r *RequestTLS) rootCAs() (*x509.CertPool, error) {
+	if r.CABundle == "" {
+		if !r.SystemRoots {
+			return nil, errors.New("no trusted root certificates, set a ca bundle or enable the system pool")
+		}
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if r.SystemRoots {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("system certificate pool: %w", err)
+		}
+		pool = systemPool
+	}
+	bundle, err := ioutil.ReadFile(r.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("ca bundle: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("ca bundle %s holds no pem certificate", r.CABundle)
+	}
+	return pool, nil
+}
+
+// spkiPins decodes the pinned base64 sha256 hashes of SubjectPublicKeyInfos
+func (r *RequestTLS) spkiPins() ([][]byte, error) {
+	pins := make([][]byte, len(r.PinnedSPKI))
+	for i, pin := range r.PinnedSPKI {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pinned spki %q is no base64 encoded sha256 hash", pin)
+		}
+		pins[i] = hash
+	}
+	return pins, nil
+}
+
+// verifyPins returns the connection check requiring a certificate
+// of a verified chain to match one of the pinned SubjectPublicKeyInfos
+func verifyPins(pins [][]byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain {
+				hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if string(hash[:]) == string(pin) {
+						return nil
+					}
+				}
+			}
+		}
+		return errors.New("no certificate of the server chain matches a pinned spki")
+	}
+}
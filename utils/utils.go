@@ -18,6 +18,9 @@ type CombinedData struct {
 	RecordTagPublic  map[string]interface{} `json:"recordtag_public"`
 	RecordDataPublic map[string]interface{} `json:"recorddata_public"`
 	KDCPublicInput   map[string]interface{} `json:"kdc_public_input"`
+	ServerIdentity   map[string]interface{} `json:"server_identity"`
+	SFPublic         map[string]interface{} `json:"sf_public"`
+	PolicyCommitment string                 `json:"policy_commitment"`
 }
 
 func ReadJSONFile(filename string) (map[string]interface{}, error) {
@@ -191,6 +194,34 @@ func ReadMM(filePath string) (map[string]string, error) {
 	return innerMapFinal, nil
 }
 
+func ReadMMKeyed(filePath string) (map[string]map[string]string, error) {
+
+	// open file
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Error().Err(err).Msg("os.Open")
+		return nil, err
+	}
+	defer file.Close()
+
+	// read in data
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Error().Err(err).Msg("io.ReadAll(file)")
+		return nil, err
+	}
+
+	// parse json, inner maps stay separated by their outer key
+	var objmap map[string]map[string]string
+	err = json.Unmarshal(data, &objmap)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &objmap)")
+		return nil, err
+	}
+
+	return objmap, nil
+}
+
 func StoreM(jsonData map[string]string, filename string) error {
 
 	file, err := json.MarshalIndent(jsonData, "", " ")
@@ -222,6 +253,16 @@ func StoreMM(mapmap map[string]map[string]string, filename string) error {
 	return nil
 }
 
+// Remove deletes a stored json file, a missing file is no error
+func Remove(filename string) error {
+	err := os.Remove("./local_storage/" + filename + ".json")
+	if err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Msg("os.Remove")
+		return err
+	}
+	return nil
+}
+
 // serialize gnark object to given file
 func Serialize(gnarkObject io.WriterTo, fileName string) {
 	f, err := os.Create(fileName)